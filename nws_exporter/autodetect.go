@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// geolocationURL is the public IP geolocation api used by -autodetect.
+const geolocationURL = "https://ipapi.co/json/"
+
+// GeolocateIP returns the approximate coordinates of our public IP address.
+func GeolocateIP(client *http.Client) (lat, lon float64, err error) {
+	resp, err := client.Get(geolocationURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != 200 {
+		return 0, 0, fmt.Errorf("err: %d, %s", resp.StatusCode, string(body))
+	}
+
+	var location struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+	if err := json.Unmarshal(body, &location); err != nil {
+		return 0, 0, err
+	}
+	if location.Latitude == 0 && location.Longitude == 0 {
+		return 0, 0, fmt.Errorf("no location for this address")
+	}
+	return location.Latitude, location.Longitude, nil
+}
+
+// RetrieveNearestStation returns the identifier of the observation station
+// nearest the given coordinates, using the NWS points api.
+func RetrieveNearestStation(client *http.Client, address string, lat, lon float64, timeout time.Duration) (string, error) {
+	var point PointResponse
+	if err := fetchJSON(client, address, fmt.Sprintf("/points/%.4f,%.4f", lat, lon), timeout, &point); err != nil {
+		return "", err
+	}
+	stationsURL, err := url.Parse(point.Properties.ObservationStations)
+	if err != nil || stationsURL.Path == "" {
+		return "", fmt.Errorf("no observation stations for %.4f,%.4f", lat, lon)
+	}
+
+	var list StationList
+	if err := fetchJSON(client, address, stationsURL.Path, timeout, &list); err != nil {
+		return "", err
+	}
+	for _, f := range list.Features {
+		if f.Properties.StationIdentifier != "" {
+			return f.Properties.StationIdentifier, nil
+		}
+	}
+	return "", fmt.Errorf("no observation stations for %.4f,%.4f", lat, lon)
+}
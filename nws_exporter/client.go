@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClientOptions configures the http.Client returned by NewClient.
+type ClientOptions struct {
+	// Timeout is the overall request timeout in seconds.
+	Timeout int
+	// DNSTimeout bounds each DNS query in seconds, so a slow resolver can't
+	// stall a scrape for longer than the request timeout suggests. Zero
+	// leaves them bounded only by the request timeout.
+	DNSTimeout int
+	// SourceAddr is the local IP address outbound connections are bound to.
+	// If empty the operating system picks the interface.
+	SourceAddr string
+	// Insecure disables TLS certificate verification for InsecureHosts.
+	// This is dangerous and only meant for internal proxies with
+	// self-signed certificates.
+	Insecure bool
+	// InsecureHosts are the hostnames Insecure applies to, so the webhook
+	// and other third party requests are still verified.
+	InsecureHosts []string
+	// AcceptLanguage is sent as the Accept-Language header of every request,
+	// so NWS returns localized text where it has it. Empty sends none.
+	AcceptLanguage string
+	// PinSHA256 are base64 SHA-256 hashes of the public keys (SPKI) that
+	// connections to PinHosts must present in their certificate chain. If
+	// empty certificates are only verified against the system roots.
+	PinSHA256 []string
+	// PinHosts are the hostnames PinSHA256 applies to, so the webhook and
+	// other third party requests aren't affected.
+	PinHosts []string
+	// KeepAlive is the interval in seconds of TCP keepalives on idle
+	// connections, which are then kept open indefinitely instead of being
+	// closed after the transport's default 90 seconds. Zero keeps the
+	// defaults.
+	KeepAlive int
+}
+
+// NewClient returns an http.Client configured from the given options.
+func NewClient(opts ClientOptions) (*http.Client, error) {
+	dnsTimeout := time.Duration(opts.DNSTimeout) * time.Second
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		// Race IPv6 and IPv4 connections (RFC 6555) so a broken address
+		// family doesn't add the full connect timeout to every scrape.
+		FallbackDelay: 300 * time.Millisecond,
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{Timeout: dnsTimeout}
+				conn, err := d.DialContext(ctx, network, address)
+				if err != nil || dnsTimeout <= 0 {
+					return conn, err
+				}
+				return conn, conn.SetDeadline(time.Now().Add(dnsTimeout))
+			},
+		},
+	}
+	if opts.SourceAddr != "" {
+		ip := net.ParseIP(opts.SourceAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address: %s", opts.SourceAddr)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.KeepAlive > 0 {
+		dialer.KeepAlive = time.Duration(opts.KeepAlive) * time.Second
+		transport.IdleConnTimeout = 0
+	}
+	transport.DialContext = dialer.DialContext
+	if len(opts.PinSHA256) > 0 {
+		transport.TLSClientConfig = &tls.Config{
+			VerifyConnection: pinVerifier(opts.PinSHA256, opts.PinHosts),
+		}
+	}
+
+	var rt http.RoundTripper = transport
+	if opts.Insecure {
+		insecure := transport.Clone()
+		if insecure.TLSClientConfig == nil {
+			insecure.TLSClientConfig = &tls.Config{}
+		}
+		insecure.TLSClientConfig.InsecureSkipVerify = true
+		rt = &hostTransport{hosts: hostSet(opts.InsecureHosts), matched: insecure, other: transport}
+	}
+	if opts.AcceptLanguage != "" {
+		rt = &headerTransport{
+			base:   rt,
+			header: http.Header{"Accept-Language": {opts.AcceptLanguage}},
+		}
+	}
+
+	return &http.Client{
+		Timeout:   time.Duration(opts.Timeout) * time.Second,
+		Transport: rt,
+	}, nil
+}
+
+// pinVerifier returns a tls.Config VerifyConnection func that fails
+// connections to any of hosts unless a certificate in the chain has a
+// public key whose base64 SHA-256 hash is one of pins. Several pins allow
+// for a key rotation, or pinning an intermediate as well as the leaf.
+func pinVerifier(pins, hosts []string) func(tls.ConnectionState) error {
+	pinned := hostSet(hosts)
+	return func(cs tls.ConnectionState) error {
+		if !pinned[strings.ToLower(cs.ServerName)] {
+			return nil
+		}
+		for _, cert := range cs.PeerCertificates {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			hash := base64.StdEncoding.EncodeToString(sum[:])
+			for _, pin := range pins {
+				if hash == pin {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no certificate for %s matches -pinsha256", cs.ServerName)
+	}
+}
+
+// keepWarm sends a HEAD request to address every interval, so the pooled
+// connection to it survives NAT and firewall idle timeouts between scrapes
+// and each scrape skips the TCP and TLS handshakes. It never returns.
+func keepWarm(client *http.Client, address string, interval time.Duration) {
+	for range time.Tick(interval) {
+		req, err := http.NewRequest(http.MethodHead, "https://"+address+"/", nil)
+		if err != nil {
+			log.Printf("Problem creating keepalive request: %v", err)
+			return
+		}
+		req, cancel := withTimeout(req, interval)
+		resp, err := client.Do(req)
+		cancel()
+		if err != nil {
+			if verbose {
+				log.Printf("Keepalive request to %s failed: %v", address, err)
+			}
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// hostSet returns the lower case hostnames of hosts, which may carry a port.
+func hostSet(hosts []string) map[string]bool {
+	set := map[string]bool{}
+	for _, h := range hosts {
+		if host, _, err := net.SplitHostPort(h); err == nil {
+			h = host
+		}
+		set[strings.ToLower(h)] = true
+	}
+	return set
+}
+
+// hostTransport sends requests to hosts through matched and everything else
+// through other, so -insecure only applies to the NWS api and its mirrors.
+// It goes by the request URL rather than the TLS server name, which is empty
+// for an IP address.
+type hostTransport struct {
+	hosts          map[string]bool
+	matched, other http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.hosts[strings.ToLower(req.URL.Hostname())] {
+		return t.matched.RoundTrip(req)
+	}
+	return t.other.RoundTrip(req)
+}
+
+// headerTransport adds default headers to every request that doesn't
+// already set them.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range t.header {
+		if req.Header.Get(key) == "" {
+			req.Header[key] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withTimeout returns a copy of req that is cancelled after timeout, along
+// with the function to release it. A zero timeout leaves only the client's
+// overall timeout in effect.
+func withTimeout(req *http.Request, timeout time.Duration) (*http.Request, context.CancelFunc) {
+	if timeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	return req.WithContext(ctx), cancel
+}
+
+// StatusError is returned for a response with an unexpected HTTP status.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+// Error implements error.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("err: %d, %s", e.Code, e.Body)
+}
+
+// maxStatusErrorBytes caps how much of an error response body is kept in a
+// StatusError.
+const maxStatusErrorBytes = 4096
+
+// newStatusError returns the StatusError for resp, whose status has already
+// been found to be unexpected. The body is only informational, so if it
+// can't be read or decompressed the error is returned without it.
+func newStatusError(resp *http.Response) error {
+	statusErr := &StatusError{Code: resp.StatusCode}
+	reader, err := decodeBody(resp)
+	if err != nil {
+		return statusErr
+	}
+	defer reader.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(reader, maxStatusErrorBytes))
+	statusErr.Body = string(body)
+	return statusErr
+}
+
+// Retriable reports whether a failed request is worth trying again. Client
+// errors such as 404 for a mistyped station or 400 for a malformed one
+// won't go away by themselves, except for 408 and 429. Server errors,
+// timeouts, dropped connections and anything else are assumed transient.
+func Retriable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code >= 400 && statusErr.Code < 500 {
+		return statusErr.Code == http.StatusRequestTimeout || statusErr.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// acceptEncoding is sent explicitly on observation requests, since some
+// proxies only compress, or only pass responses through intact, when asked.
+const acceptEncoding = "gzip, deflate"
+
+// decodeBody returns a reader for resp's body that undoes a gzip or deflate
+// Content-Encoding. The transport only decompresses transparently when it
+// asked for compression itself, so this covers requests that set
+// Accept-Encoding explicitly and proxies that compress unasked. The caller
+// must close the reader, which leaves closing resp.Body to the caller too.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but some servers send the raw
+		// stream, so check for a zlib header first
+		br := bufio.NewReader(resp.Body)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return ioutil.NopCloser(resp.Body), nil
+}
+
+// fetchJSON performs a GET request for the given NWS api path, which may
+// carry a query string, and decodes the json response body into v, giving up
+// after timeout.
+func fetchJSON(client *http.Client, address, path string, timeout time.Duration, v interface{}) error {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+	requestURL := url.URL{
+		Scheme:   "https",
+		Host:     address,
+		Path:     ref.Path,
+		RawQuery: ref.RawQuery,
+	}
+
+	req, err := http.NewRequest("GET", requestURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/geo+json")
+	req, cancel := withTimeout(req, timeout)
+	defer cancel()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return newStatusError(resp)
+	}
+
+	reader, err := decodeBody(resp)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
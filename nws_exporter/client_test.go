@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipped returns s gzip compressed.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// rawClient returns a client for srv whose transport leaves decompression
+// to decodeBody, as it does when Accept-Encoding is set explicitly.
+func rawClient(srv *httptest.Server) *http.Client {
+	client := srv.Client()
+	client.Transport.(*http.Transport).DisableCompression = true
+	return client
+}
+
+func TestFetchJSONGzip(t *testing.T) {
+	body := gzipped(t, `{"properties": {"timeZone": "Pacific/Honolulu"}}`)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	var v struct {
+		Properties struct {
+			TimeZone string `json:"timeZone"`
+		} `json:"properties"`
+	}
+	if err := fetchJSON(rawClient(srv), strings.TrimPrefix(srv.URL, "https://"), "/points/20.9,-156.4", 0, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Properties.TimeZone != "Pacific/Honolulu" {
+		t.Errorf("timeZone = %q, want Pacific/Honolulu", v.Properties.TimeZone)
+	}
+}
+
+func TestRetrieveCurrentObservationGzip(t *testing.T) {
+	body := gzipped(t, `{"properties": {"station": "https://api.weather.gov/stations/PHOG", "timestamp": "2024-06-21T12:00:00+00:00", "temperature": {"value": 26.5}}}`)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	o, err := RetrieveCurrentObservation(rawClient(srv), "PHOG", strings.TrimPrefix(srv.URL, "https://"), ObservationOptions{MaxResponseBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Properties.Temperature.Value != 26.5 {
+		t.Errorf("temperature = %v, want 26.5", o.Properties.Temperature.Value)
+	}
+}
+
+func TestStatusCheckedBeforeDecoding(t *testing.T) {
+	// An error page claiming a gzip encoding it doesn't have must still be
+	// reported by its status rather than as a gzip error
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("service unavailable"))
+	}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "https://")
+
+	var statusErr *StatusError
+	var v struct{}
+	if err := fetchJSON(rawClient(srv), address, "/stations/PHOG", 0, &v); !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Errorf("fetchJSON err = %v, want a 503 StatusError", err)
+	}
+	if _, err := RetrieveCurrentObservation(rawClient(srv), "PHOG", address, ObservationOptions{}); !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Errorf("RetrieveCurrentObservation err = %v, want a 503 StatusError", err)
+	}
+}
+
+func TestStatusErrorGzipBody(t *testing.T) {
+	body := gzipped(t, "station not found")
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	var statusErr *StatusError
+	var v struct{}
+	err := fetchJSON(rawClient(srv), strings.TrimPrefix(srv.URL, "https://"), "/stations/XXXX", 0, &v)
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound || statusErr.Body != "station not found" {
+		t.Errorf("err = %v, want a 404 with the decompressed body", err)
+	}
+}
+
+func TestInsecureOnlyForNWSHosts(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "https://")
+
+	tests := []struct {
+		name     string
+		insecure bool
+		hosts    []string
+		wantErr  bool
+	}{
+		{"the api host", true, []string{address}, false},
+		{"a mirror by hostname only", true, []string{"api.weather.gov", "127.0.0.1"}, false},
+		{"a third party host", true, []string{"api.weather.gov"}, true},
+		{"without -insecure", false, []string{address}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(ClientOptions{Timeout: 5, Insecure: tt.insecure, InsecureHosts: tt.hosts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want an error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
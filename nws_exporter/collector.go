@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// observationTime is the timestamp of the observation currently exported,
+// and when we first saw an observation with that timestamp.
+var observationTime struct {
+	sync.Mutex
+	t         time.Time
+	changedAt time.Time
+}
+
+// SetObservationTime records the timestamp of the observation the metrics
+// were last set from.
+func SetObservationTime(t time.Time) {
+	observationTime.Lock()
+	defer observationTime.Unlock()
+	if !t.Equal(observationTime.t) {
+		observationTime.changedAt = time.Now()
+	}
+	observationTime.t = t
+}
+
+// ObservationStaleSeconds returns how long the exported observation's
+// timestamp has gone unchanged across scrapes, or NaN before the first.
+func ObservationStaleSeconds() float64 {
+	observationTime.Lock()
+	defer observationTime.Unlock()
+	if observationTime.changedAt.IsZero() {
+		return math.NaN()
+	}
+	return time.Since(observationTime.changedAt).Seconds()
+}
+
+// ObservationTime returns the timestamp of the observation the metrics were
+// last set from, or the zero time if none has been recorded.
+func ObservationTime() time.Time {
+	observationTime.Lock()
+	defer observationTime.Unlock()
+	return observationTime.t
+}
+
+// timestampCollector wraps collectors so every metric they emit carries the
+// time of the observation it came from rather than the scrape time.
+type timestampCollector struct {
+	collectors []prometheus.Collector
+}
+
+// Describe implements prometheus.Collector.
+func (c *timestampCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, col := range c.collectors {
+		col.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *timestampCollector) Collect(ch chan<- prometheus.Metric) {
+	ts := ObservationTime()
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		for _, col := range c.collectors {
+			col.Collect(metrics)
+		}
+		close(metrics)
+	}()
+	for m := range metrics {
+		if ts.IsZero() {
+			ch <- m
+			continue
+		}
+		ch <- prometheus.NewMetricWithTimestamp(ts, m)
+	}
+}
+
+// missingReadings are the observation gauges left out of /metrics by
+// -onmissing clear until their reading returns.
+var missingReadings = struct {
+	sync.Mutex
+	collectors map[prometheus.Collector]bool
+}{collectors: map[prometheus.Collector]bool{}}
+
+// setMissing marks whether c is currently missing its reading.
+func setMissing(c prometheus.Collector, missing bool) {
+	missingReadings.Lock()
+	defer missingReadings.Unlock()
+	if missing {
+		missingReadings.collectors[c] = true
+	} else {
+		delete(missingReadings.collectors, c)
+	}
+}
+
+// isMissing reports whether c is currently missing its reading.
+func isMissing(c prometheus.Collector) bool {
+	missingReadings.Lock()
+	defer missingReadings.Unlock()
+	return missingReadings.collectors[c]
+}
+
+// missingCollector wraps collectors so that those marked missing by
+// setMissing aren't collected.
+type missingCollector struct {
+	collectors []prometheus.Collector
+}
+
+// Describe implements prometheus.Collector.
+func (c *missingCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, col := range c.collectors {
+		col.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *missingCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, col := range c.collectors {
+		if !isMissing(col) {
+			col.Collect(ch)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Conditions is a snapshot of the last successful scrape: the observation
+// the metrics were set from, in the metric units NWS reports, and the sun
+// position computed alongside it, unless the sun subsystem is disabled.
+type Conditions struct {
+	Station     string                `json:"station"`
+	ScrapedAt   time.Time             `json:"scraped_at"`
+	Observation ObservationProperties `json:"observation"`
+	Sun         *SunPosition          `json:"sun,omitempty"`
+}
+
+// lastConditions holds the most recent Conditions so HTTP handlers can serve
+// them without waiting on the NWS api.
+var lastConditions struct {
+	sync.RWMutex
+	c *Conditions
+}
+
+// SetLastConditions records c as the most recent successful scrape.
+func SetLastConditions(c Conditions) {
+	lastConditions.Lock()
+	defer lastConditions.Unlock()
+	lastConditions.c = &c
+}
+
+// LastConditions returns the most recent successful scrape, or false if there
+// hasn't been one yet.
+func LastConditions() (Conditions, bool) {
+	lastConditions.RLock()
+	defer lastConditions.RUnlock()
+	if lastConditions.c == nil {
+		return Conditions{}, false
+	}
+	return *lastConditions.c, true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config is the effective configuration of the running exporter, as served
+// by /config. Values that can change after startup, such as the station
+// after a /reload or the autodetected coordinates, are reported as they are
+// now rather than as given on the command line.
+type Config struct {
+	Station          string            `json:"station"`
+	FallbackStations []string          `json:"fallback_stations"`
+	Latitude         float64           `json:"latitude"`
+	Longitude        float64           `json:"longitude"`
+	Timezone         string            `json:"timezone"`
+	Interval         string            `json:"interval"`
+	Flags            map[string]string `json:"flags"`
+}
+
+// EffectiveConfig returns the current Config, with every flag value passed
+// through redactSecrets.
+func EffectiveConfig() Config {
+	flags := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		// The station and coordinates can change under stationMu, so they
+		// are only reported through activeStation and activeCoordinates
+		switch f.Name {
+		case "station", "latitude", "longitude":
+			return
+		case "reloadtoken":
+			if f.Value.String() != "" {
+				flags[f.Name] = "REDACTED"
+				return
+			}
+		}
+		flags[f.Name] = redactSecrets(f.Value.String())
+	})
+	lat, lon := activeCoordinates()
+	return Config{
+		Station:          activeStation(),
+		FallbackStations: fallbackStations,
+		Latitude:         lat,
+		Longitude:        lon,
+		Timezone:         localZone.String(),
+		Interval:         (time.Duration(backofftime) * time.Second).String(),
+		Flags:            flags,
+	}
+}
+
+// redactSecrets hides the password and query string of any URLs in a comma
+// separated flag value, where credentials for a webhook or mirror would be.
+func redactSecrets(value string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		u, err := url.Parse(part)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if u.RawQuery != "" {
+			u.RawQuery = "REDACTED"
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		parts[i] = u.String()
+	}
+	return strings.Join(parts, ",")
+}
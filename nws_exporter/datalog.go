@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DataLog appends scraped conditions to a JSON lines file for offline
+// analysis, rotating it at the start of each local day and whenever it grows
+// past a size limit. Rotated files get a timestamp suffix.
+type DataLog struct {
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+	day      string
+}
+
+// NewDataLog opens or creates the log at path. A maxBytes of 0 only rotates
+// daily.
+func NewDataLog(path string, maxBytes int64) (*DataLog, error) {
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("data log size limit must not be negative, got %d", maxBytes)
+	}
+	l := &DataLog{path: path, maxBytes: maxBytes}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	// An existing file is continued, and rotated on the first write if it
+	// was last written on an earlier day
+	if info, err := l.file.Stat(); err == nil {
+		l.day = info.ModTime().In(localZone).Format("2006-01-02")
+	}
+	return l, nil
+}
+
+// open opens the log file for appending.
+func (l *DataLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the current file aside and starts a new one.
+func (l *DataLog) rotate(now time.Time) error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, l.path+"."+now.Format("20060102-150405")); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Write appends c as a single JSON line, rotating first if a new day has
+// started or the line would take the file past the size limit.
+func (l *DataLog) Write(c Conditions) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	now := time.Now().In(localZone)
+	day := now.Format("2006-01-02")
+	newDay := l.day != "" && day != l.day
+	full := l.maxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxBytes
+	if newDay || full {
+		if err := l.rotate(now); err != nil {
+			return err
+		}
+	}
+	l.day = day
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// deadbands maps gauges to the smallest change, in their exported units,
+// that setReading will apply. It is only written by main before scraping
+// starts.
+var deadbands = map[prometheus.Collector]float64{}
+
+// readingGauges returns the gauges -deadband and -precision can be set for,
+// by the same names -ranges uses.
+func readingGauges() map[string]prometheus.Gauge {
+	return map[string]prometheus.Gauge{
+		"humidity":             humidity,
+		"temperature":          temperature,
+		"temperature_max_24h":  temperatureMax24h,
+		"temperature_min_24h":  temperatureMin24h,
+		"dewpoint":             dewpoint,
+		"wind_speed":           windspeed,
+		"barometric_pressure":  barometricpressure,
+		"visibility":           visibility,
+		"apparent_temperature": apparentTemp,
+		"thsw_index":           thswIndex,
+		"estimated_cloud_base": cloudBase,
+	}
+}
+
+// ParseDeadbands sets deadbands from spec, a comma separated list of
+// name=epsilon entries, e.g. "temperature=0.1,barometric_pressure=10".
+func ParseDeadbands(spec string) error {
+	gauges := readingGauges()
+	for _, entry := range strings.Split(spec, ",") {
+		name, eps, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return fmt.Errorf("invalid deadband %q, expected name=epsilon", entry)
+		}
+		g, ok := gauges[name]
+		if !ok {
+			return fmt.Errorf("unknown deadband metric %q, expected one of %s", name, readingNames(gauges))
+		}
+		epsilon, err := strconv.ParseFloat(eps, 64)
+		if err != nil || epsilon < 0 {
+			return fmt.Errorf("invalid deadband %q, epsilon must be a non-negative number", entry)
+		}
+		deadbands[g] = epsilon
+	}
+	return nil
+}
+
+// readingNames lists the names of gauges, sorted, for error messages.
+func readingNames(gauges map[string]prometheus.Gauge) string {
+	names := make([]string, 0, len(gauges))
+	for n := range gauges {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// withinDeadband reports whether value is within g's deadband of the value
+// g currently holds, so setting it can be skipped.
+func withinDeadband(g prometheus.Gauge, value float64) bool {
+	epsilon, ok := deadbands[g]
+	if !ok {
+		return false
+	}
+	var m dto.Metric
+	if err := g.Write(&m); err != nil || m.Gauge == nil {
+		return false
+	}
+	return math.Abs(m.Gauge.GetValue()-value) <= epsilon
+}
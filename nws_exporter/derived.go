@@ -0,0 +1,120 @@
+package main
+
+import "math"
+
+// HeatIndex returns the NWS heat index in celsius for the given air
+// temperature in celsius and relative humidity in percent, using the
+// Rothfusz regression with the NWS low and high humidity adjustments. Below
+// roughly 27°C the heat index is not meaningful and is close to the air
+// temperature, so the simple Steadman formula is used instead.
+func HeatIndex(tempC, rh float64) float64 {
+	t := tempC*9/5 + 32
+
+	hi := 0.5 * (t + 61.0 + (t-68.0)*1.2 + rh*0.094)
+	if (hi+t)/2 >= 80 {
+		hi = -42.379 + 2.04901523*t + 10.14333127*rh -
+			0.22475541*t*rh - 0.00683783*t*t - 0.05481717*rh*rh +
+			0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+		if rh < 13 && t >= 80 && t <= 112 {
+			hi -= ((13 - rh) / 4) * math.Sqrt((17-math.Abs(t-95))/17)
+		} else if rh > 85 && t >= 80 && t <= 87 {
+			hi += ((rh - 85) / 10) * ((87 - t) / 5)
+		}
+	}
+
+	return (hi - 32) * 5 / 9
+}
+
+// WindChill returns the wind chill in celsius for the given air temperature
+// in celsius and wind speed in kilometers per hour, using the 2001 NWS and
+// Environment Canada formula. Outside its defined range (above 10°C or below
+// 4.8 km/h) the air temperature is returned unchanged.
+func WindChill(tempC, windKmh float64) float64 {
+	if tempC > 10 || windKmh < 4.8 {
+		return tempC
+	}
+	v := math.Pow(windKmh, 0.16)
+	return 13.12 + 0.6215*tempC - 11.37*v + 0.3965*tempC*v
+}
+
+// windChillApplies reports whether it is cold and windy enough for the wind
+// chill to be meaningful.
+func windChillApplies(tempC, windKmh float64) bool {
+	return tempC <= 10 && windKmh >= 4.8
+}
+
+// heatIndexApplies reports whether it is hot and humid enough for the heat
+// index to be meaningful.
+func heatIndexApplies(tempC, rh float64) bool {
+	return tempC >= 26.7 && rh >= 40
+}
+
+// ApparentTemperature returns the "feels like" temperature in celsius the
+// way weather services present it: the wind chill when it is cold and windy
+// enough for it to apply, the heat index when it is hot and humid enough,
+// and the air temperature in between.
+func ApparentTemperature(tempC, rh, windKmh float64) float64 {
+	switch {
+	case windChillApplies(tempC, windKmh):
+		return WindChill(tempC, windKmh)
+	case heatIndexApplies(tempC, rh):
+		return HeatIndex(tempC, rh)
+	default:
+		return tempC
+	}
+}
+
+// THSWIndex returns a rough temperature-humidity-sun-wind apparent
+// temperature in celsius. It starts from the air temperature, adds the heat
+// index's humidity effect and the wind chill's wind effect, then adds a solar
+// heating term from Steadman's apparent temperature, 0.7*Q/(ws+10), where Q
+// is taken as a quarter of the estimated irradiance in W/m² (roughly what a
+// person absorbs) and ws is the wind speed in m/s. It is an approximation
+// meant for dashboards, not a reproduction of any vendor's THSW formula.
+func THSWIndex(tempC, rh, windKmh, irradiance float64) float64 {
+	humidityEffect := HeatIndex(tempC, rh) - tempC
+	if humidityEffect < 0 {
+		humidityEffect = 0
+	}
+	windEffect := WindChill(tempC, windKmh) - tempC
+
+	ws := windKmh / 3.6
+	solarEffect := 0.7 * (irradiance / 4) / (ws + 10)
+
+	return tempC + humidityEffect + windEffect + solarEffect
+}
+
+// RelativeHumidity returns the relative humidity in percent for the given
+// air temperature and dewpoint in celsius, using the Magnus formula.
+func RelativeHumidity(tempC, dewpointC float64) float64 {
+	const b, c = 17.625, 243.04
+	return 100 * math.Exp(b*dewpointC/(c+dewpointC)-b*tempC/(c+tempC))
+}
+
+// CloudBase estimates the height in meters above the station of the base of
+// cumulus clouds, the lifted condensation level, from the air temperature and
+// dewpoint in celsius with Espy's approximation of 125 meters per degree of
+// spread. A dewpoint above the temperature gives zero, fog at the surface. It
+// returns false if either reading is missing, which like elsewhere is zero.
+func CloudBase(tempC, dewpointC float64) (float64, bool) {
+	if tempC == 0 || dewpointC == 0 {
+		return 0, false
+	}
+	return 125 * math.Max(tempC-dewpointC, 0), true
+}
+
+// WindComponents splits a wind speed and the direction in degrees it blows
+// from into its east-west (u) and north-south (v) components, speed*sin(dir)
+// and speed*cos(dir), which unlike degrees can be averaged over time.
+func WindComponents(speed, direction float64) (u, v float64) {
+	rad := direction * math.Pi / 180
+	return speed * math.Sin(rad), speed * math.Cos(rad)
+}
+
+// SeaLevelPressure reduces a station pressure in pascals to sea level using
+// the barometric formula, given the station elevation in meters and the air
+// temperature in celsius.
+func SeaLevelPressure(stationPa, elevationM, tempC float64) float64 {
+	h := 0.0065 * elevationM
+	return stationPa * math.Pow(1-h/(tempC+h+273.15), -5.257)
+}
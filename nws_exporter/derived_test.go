@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestApparentTemperature(t *testing.T) {
+	tests := []struct {
+		name    string
+		tempC   float64
+		rh      float64
+		windKmh float64
+		want    float64
+	}{
+		// Environment Canada's table gives -18 for -10°C at 20 km/h
+		{"wind chill", -10, 50, 20, WindChill(-10, 20)},
+		// The NWS heat index chart gives 106°F for 90°F at 70%
+		{"heat index", 32.2, 70, 10, HeatIndex(32.2, 70)},
+		{"plain", 20, 60, 15, 20},
+
+		{"wind chill at its temperature limit", 10, 50, 20, WindChill(10, 20)},
+		{"just too warm for wind chill", 10.1, 50, 20, 10.1},
+		{"wind chill at its wind limit", 0, 50, 4.8, WindChill(0, 4.8)},
+		{"just too calm for wind chill", 0, 50, 4.7, 0},
+		{"heat index at its temperature limit", 26.7, 60, 10, HeatIndex(26.7, 60)},
+		{"just too cool for heat index", 26.6, 60, 10, 26.6},
+		{"heat index at its humidity limit", 30, 40, 10, HeatIndex(30, 40)},
+		{"just too dry for heat index", 30, 39.9, 10, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ApparentTemperature(tt.tempC, tt.rh, tt.windKmh); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("ApparentTemperature(%v, %v, %v) = %v, want %v", tt.tempC, tt.rh, tt.windKmh, got, tt.want)
+			}
+		})
+	}
+
+	if got := ApparentTemperature(-10, 50, 20); math.Abs(got-(-17.9)) > 0.1 {
+		t.Errorf("wind chill for -10°C at 20 km/h = %.2f, want about -17.9", got)
+	}
+	if got := ApparentTemperature(32.2, 70, 10)*9/5 + 32; math.Abs(got-106) > 1 {
+		t.Errorf("heat index for 90°F at 70%% = %.1f°F, want about 106°F", got)
+	}
+}
+
+func TestCloudBase(t *testing.T) {
+	tests := []struct {
+		name        string
+		tempC       float64
+		dewpointC   float64
+		want        float64
+		wantPresent bool
+	}{
+		{"spread of 8°", 26, 18, 1000, true},
+		{"spread of 0.4°", 20.4, 20, 50, true},
+		{"saturated", 15, 15, 0, true},
+		{"dewpoint above temperature", 15, 16, 0, true},
+		{"below freezing", -5, -9, 500, true},
+		{"missing dewpoint", 26, 0, 0, false},
+		{"missing temperature", 0, 18, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, present := CloudBase(tt.tempC, tt.dewpointC)
+			if present != tt.wantPresent || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("CloudBase(%v, %v) = %v, %v, want %v, %v", tt.tempC, tt.dewpointC, got, present, tt.want, tt.wantPresent)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// LoadFieldMap reads a JSON object mapping observation property names as
+// NWS currently sends them to the names we expect, e.g.
+// {"airTemperature": "temperature"}, for when the api schema drifts before
+// a new release can catch up.
+func LoadFieldMap(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fieldMap map[string]string
+	if err := json.Unmarshal(data, &fieldMap); err != nil {
+		return nil, fmt.Errorf("parsing field map %s: %v", path, err)
+	}
+	return fieldMap, nil
+}
+
+// remapFields renames the properties of the observation in body according
+// to fieldMap, or of every observation if body is an observation list, and
+// returns the re-encoded body ready to unmarshal.
+func remapFields(body []byte, fieldMap map[string]string, list bool) ([]byte, error) {
+	if !list {
+		return remapObservation(body, fieldMap)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	var features []json.RawMessage
+	if err := json.Unmarshal(doc["features"], &features); err != nil {
+		return nil, err
+	}
+	for i, feature := range features {
+		remapped, err := remapObservation(feature, fieldMap)
+		if err != nil {
+			return nil, err
+		}
+		features[i] = remapped
+	}
+	encoded, err := json.Marshal(features)
+	if err != nil {
+		return nil, err
+	}
+	doc["features"] = encoded
+	return json.Marshal(doc)
+}
+
+// remapObservation renames the keys of a single observation's properties.
+// A mapped name replaces any property already using the target name.
+func remapObservation(observation []byte, fieldMap map[string]string) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(observation, &doc); err != nil {
+		return nil, err
+	}
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(doc["properties"], &properties); err != nil || properties == nil {
+		// Missing or null properties are left for the caller to reject
+		return observation, nil
+	}
+	for from, to := range fieldMap {
+		if value, ok := properties[from]; ok {
+			delete(properties, from)
+			properties[to] = value
+		}
+	}
+	encoded, err := json.Marshal(properties)
+	if err != nil {
+		return nil, err
+	}
+	doc["properties"] = encoded
+	return json.Marshal(doc)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// forecastInterval is how often the daily forecast is refetched. NWS only
+// updates it a few times a day, so there is no point polling it every scrape.
+const forecastInterval = time.Hour
+
+// ForecastResponse is the subset of the NWS gridpoint forecast response we
+// use.
+type ForecastResponse struct {
+	Properties struct {
+		Periods []ForecastPeriod `json:"periods"`
+	} `json:"properties"`
+}
+
+// ForecastPeriod is a single daytime or overnight period of the forecast.
+type ForecastPeriod struct {
+	StartTime       time.Time `json:"startTime"`
+	IsDaytime       bool      `json:"isDaytime"`
+	Temperature     float64   `json:"temperature"`
+	TemperatureUnit string    `json:"temperatureUnit"`
+}
+
+// forecastPath is the gridpoint forecast path for forecastCoordinates,
+// resolved from the points api on the first poll and again after /reload
+// moves the sun coordinates, and forecastFetched is when the forecast was
+// last fetched.
+var (
+	forecastPath        string
+	forecastCoordinates [2]float64
+	forecastFetched     time.Time
+)
+
+// DailyHighsLows pairs the daytime and overnight forecast periods into the
+// high and low in celsius for each day, numbered from 0 for today in the
+// local timezone. A night belongs to the day it starts on, so tonight's low
+// is day 0's.
+func DailyHighsLows(periods []ForecastPeriod, now time.Time) (highs, lows map[int]float64) {
+	highs, lows = map[int]float64{}, map[int]float64{}
+	y, m, d := now.In(localZone).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	for _, p := range periods {
+		y, m, d := p.StartTime.In(localZone).Date()
+		day := int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(today).Hours() / 24)
+		if day < 0 {
+			continue
+		}
+		celsius := p.Temperature
+		if p.TemperatureUnit == "F" {
+			celsius = (p.Temperature - 32) * 5 / 9
+		}
+		if p.IsDaytime {
+			highs[day] = celsius
+		} else {
+			lows[day] = celsius
+		}
+	}
+	return highs, lows
+}
+
+// pollForecast refreshes the forecast high and low gauges, at most once
+// every forecastInterval.
+func pollForecast(client *http.Client) error {
+	latitude, longitude := activeCoordinates()
+	if forecastPath != "" && forecastCoordinates != [2]float64{latitude, longitude} {
+		forecastPath = ""
+		forecastFetched = time.Time{}
+	}
+	if time.Since(forecastFetched) < forecastInterval {
+		return nil
+	}
+	timeout := endpointTimeout(metadatatimeout)
+	if forecastPath == "" {
+		var point PointResponse
+		if err := fetchJSON(client, address, fmt.Sprintf("/points/%.4f,%.4f", latitude, longitude), timeout, &point); err != nil {
+			return err
+		}
+		forecastURL, err := url.Parse(point.Properties.Forecast)
+		if err != nil || forecastURL.Path == "" {
+			return fmt.Errorf("no forecast for %.4f,%.4f", latitude, longitude)
+		}
+		forecastPath = forecastURL.Path
+		forecastCoordinates = [2]float64{latitude, longitude}
+		log.Printf("Using forecast %s", forecastPath)
+	}
+
+	var forecast ForecastResponse
+	if err := fetchJSON(client, address, forecastPath, timeout, &forecast); err != nil {
+		return err
+	}
+	highs, lows := DailyHighsLows(forecast.Properties.Periods, time.Now())
+	forecastHigh.Reset()
+	for day, t := range highs {
+		forecastHigh.WithLabelValues(strconv.Itoa(day)).Set(ConvertTemperature(t, units))
+	}
+	forecastLow.Reset()
+	for day, t := range lows {
+		forecastLow.WithLabelValues(strconv.Itoa(day)).Set(ConvertTemperature(t, units))
+	}
+	forecastFetched = time.Now()
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSunForecastDays caps how far ahead the /sun endpoint will compute.
+const maxSunForecastDays = 30
+
+// writeJSON serializes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+// sunHandler serves the sunrise, sunset, solar noon and day length for the
+// next few days as JSON. The number of days defaults to 7 and can be set with
+// the days query parameter. With a RFC 3339 time query parameter it instead
+// serves the sun position at that instant.
+func sunHandler(w http.ResponseWriter, r *http.Request) {
+	if ts := r.URL.Query().Get("time"); ts != "" {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			http.Error(w, "time must be RFC 3339, e.g. 2024-06-21T12:00:00Z", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, CalculateSunPosition(t))
+		return
+	}
+
+	days := 7
+	if d := r.URL.Query().Get("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 || n > maxSunForecastDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxSunForecastDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	writeJSON(w, SunForecast(offsetNow(), days))
+}
+
+// conditionsHandler serves the last scraped observation and sun position as
+// JSON. It never calls the NWS api, so it returns 503 until the first
+// successful scrape.
+func conditionsHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := LastConditions()
+	if !ok {
+		http.Error(w, "no observation scraped yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, c)
+}
+
+// configHandler serves the effective configuration as JSON, with any
+// credentials in URLs redacted.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, EffectiveConfig())
+}
+
+// reloadAuthorized reports whether r may use /reload: it must carry
+// -reloadtoken as a bearer token, or come from a loopback address if no
+// token is set.
+func reloadAuthorized(r *http.Request) bool {
+	if reloadtoken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(token), []byte(reloadtoken)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// reloadHandler switches the primary station and optionally the sun
+// coordinates at runtime, for a kiosk that moves between sites. It only
+// accepts authorized POST requests with the new station in the station form
+// value, and the coordinates in latitude and longitude, which must be given
+// together. Everything is validated before any of it is applied, and then
+// applied at once.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !reloadAuthorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	newStation := r.FormValue("station")
+	if err := ValidateStation(newStation); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lat, lon := activeCoordinates()
+	latValue, lonValue := r.FormValue("latitude"), r.FormValue("longitude")
+	if (latValue == "") != (lonValue == "") {
+		http.Error(w, "latitude and longitude must be given together", http.StatusBadRequest)
+		return
+	}
+	if latValue != "" {
+		var err1, err2 error
+		lat, err1 = strconv.ParseFloat(latValue, 64)
+		lon, err2 = strconv.ParseFloat(lonValue, 64)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "latitude and longitude must be numbers", http.StatusBadRequest)
+			return
+		}
+		if err := ValidateCoordinates(lat, lon); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	old := activeStation()
+	setActiveSite(newStation, lat, lon)
+	log.Printf("Reloaded station %s -> %s at %.4f,%.4f", old, newStation, lat, lon)
+	fmt.Fprintf(w, "station %s at %.4f,%.4f\n", newStation, lat, lon)
+}
+
+// healthzHandler reports whether the exporter has scraped an observation, for
+// container health checks. It returns 503 until the first successful scrape.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := LastConditions(); !ok {
+		http.Error(w, "no observation scraped yet", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// healthCheckTimeout bounds the -healthcheck request.
+const healthCheckTimeout = 5 * time.Second
+
+// HealthCheck requests /healthz from the exporter listening on listenAddr
+// and returns nil if it is healthy. A wildcard or empty listen host is
+// reached through localhost.
+func HealthCheck(listenAddr string) error {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	client := &http.Client{Timeout: healthCheckTimeout}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("err: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ListenAndServeAll binds every address in addrs and serves handler on all of
+// them. If any address can't be bound it returns an error before serving on
+// any, and otherwise it returns the first error from any of the servers.
+func ListenAndServeAll(addrs []string, handler http.Handler) error {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return fmt.Errorf("listening on %s: %v", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			server := &http.Server{Handler: handler}
+			errs <- fmt.Errorf("serving on %s: %v", l.Addr(), server.Serve(l))
+		}(l)
+	}
+	return <-errs
+}
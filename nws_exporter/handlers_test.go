@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReloadHandler(t *testing.T) {
+	defer setActiveSite(activeStation(), latitude, longitude)
+	defer func(token string) { reloadtoken = token }(reloadtoken)
+
+	tests := []struct {
+		name       string
+		token      string
+		remote     string
+		auth       string
+		form       url.Values
+		wantStatus int
+		wantLat    float64
+		wantLon    float64
+	}{
+		{"station only", "", "127.0.0.1:1234", "", url.Values{"station": {"PHNY"}}, http.StatusOK, 20.8986, -156.4306},
+		{"with coordinates", "", "[::1]:1234", "", url.Values{"station": {"PHNY"}, "latitude": {"20.7858"}, "longitude": {"-156.9514"}}, http.StatusOK, 20.7858, -156.9514},
+		{"latitude only", "", "127.0.0.1:1234", "", url.Values{"station": {"PHNY"}, "latitude": {"20.7858"}}, http.StatusBadRequest, 20.8986, -156.4306},
+		{"latitude out of range", "", "127.0.0.1:1234", "", url.Values{"station": {"PHNY"}, "latitude": {"91"}, "longitude": {"0"}}, http.StatusBadRequest, 20.8986, -156.4306},
+		{"bad station", "", "127.0.0.1:1234", "", url.Values{"station": {"not a station"}}, http.StatusBadRequest, 20.8986, -156.4306},
+		{"remote without token", "", "192.0.2.1:1234", "", url.Values{"station": {"PHNY"}}, http.StatusForbidden, 20.8986, -156.4306},
+		{"remote with token", "secret", "192.0.2.1:1234", "Bearer secret", url.Values{"station": {"PHNY"}}, http.StatusOK, 20.8986, -156.4306},
+		{"loopback with wrong token", "secret", "127.0.0.1:1234", "Bearer wrong", url.Values{"station": {"PHNY"}}, http.StatusForbidden, 20.8986, -156.4306},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setActiveSite("PHOG", 20.8986, -156.4306)
+			reloadtoken = tt.token
+
+			req := httptest.NewRequest(http.MethodPost, "/reload", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.RemoteAddr = tt.remote
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			reloadHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			wantStation := "PHOG"
+			if tt.wantStatus == http.StatusOK {
+				wantStation = tt.form.Get("station")
+			}
+			if got := activeStation(); got != wantStation {
+				t.Errorf("station = %s, want %s", got, wantStation)
+			}
+			if lat, lon := activeCoordinates(); lat != tt.wantLat || lon != tt.wantLon {
+				t.Errorf("coordinates = %v,%v, want %v,%v", lat, lon, tt.wantLat, tt.wantLon)
+			}
+		})
+	}
+}
+
+func TestReloadHandlerMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	reloadHandler(rec, httptest.NewRequest(http.MethodGet, "/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
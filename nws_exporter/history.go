@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxHistoryPages bounds how many pages of the observation list
+// RetrieveObservationHistory follows, however long -historyhours is.
+const maxHistoryPages = 10
+
+// RetrieveObservationHistory returns the station's observations from the
+// last window, newest first, following the list's pagination links until
+// they run out, reach past the window or hit maxHistoryPages.
+func RetrieveObservationHistory(client *http.Client, station, address string, window, timeout time.Duration) ([]ObservationResponse, error) {
+	start := time.Now().Add(-window)
+	path := fmt.Sprintf("/stations/%s/observations?start=%s", station, url.QueryEscape(start.UTC().Format(time.RFC3339)))
+
+	var history []ObservationResponse
+	for page := 0; page < maxHistoryPages && path != ""; page++ {
+		var list ObservationList
+		if err := fetchJSON(client, address, path, timeout, &list); err != nil {
+			return history, err
+		}
+		if len(list.Features) == 0 {
+			break
+		}
+		for _, obs := range list.Features {
+			if obs.Properties.Timestamp.Before(start) {
+				return history, nil
+			}
+			history = append(history, obs)
+		}
+
+		path = ""
+		if next, err := url.Parse(list.Pagination.Next); err == nil && next.Path != "" {
+			path = next.RequestURI()
+		}
+	}
+	return history, nil
+}
+
+// PressureChange returns the change in barometric pressure in pascals from
+// the oldest to the newest observation in history that report one, or false
+// if fewer than two do.
+func PressureChange(history []ObservationResponse) (float64, bool) {
+	var newest, oldest *ObservationResponse
+	for i := range history {
+		obs := &history[i]
+		if obs.Properties.BarometricPressure.Value == 0 {
+			continue
+		}
+		if newest == nil || obs.Properties.Timestamp.After(newest.Properties.Timestamp) {
+			newest = obs
+		}
+		if oldest == nil || obs.Properties.Timestamp.Before(oldest.Properties.Timestamp) {
+			oldest = obs
+		}
+	}
+	if newest == nil || newest == oldest {
+		return 0, false
+	}
+	return newest.Properties.BarometricPressure.Value - oldest.Properties.BarometricPressure.Value, true
+}
+
+// pollHistory fetches the last -historyhours of observations for the station
+// and sets the pressure change over that window.
+func pollHistory(client *http.Client, station string) error {
+	window := time.Duration(historyhours) * time.Hour
+	history, err := RetrieveObservationHistory(client, station, address, window, endpointTimeout(observationtimeout))
+	if err != nil {
+		return err
+	}
+	change, ok := PressureChange(history)
+	setReading(pressureChange, ConvertPressure(change, units), ok)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// iconNames maps the NWS icon condition codes to a normalized icon set,
+// using the names common to weather icon fonts. Names ending in "-day" have
+// a "-night" variant chosen by WeatherIcon.
+var iconNames = map[string]string{
+	"skc":             "clear-day",
+	"few":             "clear-day",
+	"sct":             "partly-cloudy-day",
+	"bkn":             "partly-cloudy-day",
+	"ovc":             "cloudy",
+	"wind_skc":        "wind",
+	"wind_few":        "wind",
+	"wind_sct":        "wind",
+	"wind_bkn":        "wind",
+	"wind_ovc":        "wind",
+	"snow":            "snow",
+	"blizzard":        "snow",
+	"rain_snow":       "sleet",
+	"rain_sleet":      "sleet",
+	"snow_sleet":      "sleet",
+	"sleet":           "sleet",
+	"fzra":            "sleet",
+	"rain_fzra":       "sleet",
+	"snow_fzra":       "sleet",
+	"rain":            "rain",
+	"rain_showers":    "rain",
+	"rain_showers_hi": "rain",
+	"tsra":            "thunderstorm",
+	"tsra_sct":        "thunderstorm",
+	"tsra_hi":         "thunderstorm",
+	"tornado":         "tornado",
+	"hurricane":       "hurricane",
+	"tropical_storm":  "hurricane",
+	"fog":             "fog",
+	"haze":            "fog",
+	"smoke":           "fog",
+	"dust":            "fog",
+	"hot":             "clear-day",
+	"cold":            "clear-day",
+}
+
+// iconKeywords maps words in an observation's text description to icons,
+// for observations without an icon URL. The first match wins, so more
+// specific conditions come first.
+var iconKeywords = []struct {
+	keyword, icon string
+}{
+	{"thunder", "thunderstorm"},
+	{"tornado", "tornado"},
+	{"freezing", "sleet"},
+	{"sleet", "sleet"},
+	{"snow", "snow"},
+	{"rain", "rain"},
+	{"drizzle", "rain"},
+	{"shower", "rain"},
+	{"fog", "fog"},
+	{"mist", "fog"},
+	{"haze", "fog"},
+	{"smoke", "fog"},
+	{"wind", "wind"},
+	{"overcast", "cloudy"},
+	{"mostly cloudy", "partly-cloudy-day"},
+	{"partly", "partly-cloudy-day"},
+	{"cloudy", "cloudy"},
+	{"clear", "clear-day"},
+	{"sunny", "clear-day"},
+	{"fair", "clear-day"},
+}
+
+// ParseIcon extracts the condition code, e.g. ovc, and the daypart, day or
+// night, from an NWS icon URL such as
+// https://api.weather.gov/icons/land/day/sct?size=medium. Multiple conditions
+// are separated by a slash, the first is the current one, and each may carry
+// a probability, e.g. rain,40. It returns empty strings if the URL has no
+// daypart segment.
+func ParseIcon(iconURL string) (code, daypart string) {
+	u, err := url.Parse(iconURL)
+	if err != nil || u.Path == "" {
+		return "", ""
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if (segment == "day" || segment == "night") && i+1 < len(segments) {
+			return strings.SplitN(segments[i+1], ",", 2)[0], segment
+		}
+	}
+	return "", ""
+}
+
+// WeatherIcon returns the normalized icon name for an observation, from the
+// condition code in its NWS icon URL, e.g.
+// https://api.weather.gov/icons/land/day/sct?size=medium, or failing that
+// from its text description. Icons with day and night variants use the
+// night one when daylight is false. It returns "unknown" if neither matches.
+func WeatherIcon(iconURL, textDescription string, daylight bool) string {
+	code, _ := ParseIcon(iconURL)
+	icon := iconNames[code]
+	if icon == "" {
+		description := strings.ToLower(textDescription)
+		for _, k := range iconKeywords {
+			if strings.Contains(description, k.keyword) {
+				icon = k.icon
+				break
+			}
+		}
+	}
+	if icon == "" {
+		return "unknown"
+	}
+	if !daylight && strings.HasSuffix(icon, "-day") {
+		icon = strings.TrimSuffix(icon, "-day") + "-night"
+	}
+	return icon
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxJitter is the largest -jitter percentage accepted, so a jittered
+// interval is never less than half of backofftime.
+const maxJitter = 50.0
+
+// jitterMu guards jitterRand, which the scrape loop and startup both draw
+// from.
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// ValidateJitter checks the -jitter percentage is between 0 and maxJitter.
+func ValidateJitter(percent float64) error {
+	if percent < 0 || percent > maxJitter {
+		return fmt.Errorf("jitter %v%% must be between 0 and %v%%", percent, maxJitter)
+	}
+	return nil
+}
+
+// randomFraction returns a uniformly random number in [0, 1).
+func randomFraction() float64 {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return jitterRand.Float64()
+}
+
+// startupDelay returns a random delay of up to jitter percent of
+// backofftime, so instances started together don't all scrape in lockstep.
+func startupDelay() time.Duration {
+	interval := time.Duration(backofftime) * time.Second
+	return time.Duration(randomFraction() * jitter / 100 * float64(interval))
+}
+
+// scrapeInterval returns backofftime randomly spread by ±jitter percent,
+// but never below mininterval.
+func scrapeInterval() time.Duration {
+	interval := time.Duration(backofftime) * time.Second
+	spread := (2*randomFraction() - 1) * jitter / 100
+	interval += time.Duration(spread * float64(interval))
+	if floor := time.Duration(mininterval) * time.Second; interval < floor {
+		interval = floor
+	}
+	return interval
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// earthRadiusKm is the mean radius of the Earth used for great-circle
+// distances.
+const earthRadiusKm = 6371.0
+
+// StationResponse is the part of the NWS station metadata we use.
+type StationResponse struct {
+	Geometry struct {
+		Coordinates []float64 `json:"coordinates"` // longitude, latitude
+	} `json:"geometry"`
+}
+
+// ValidateCoordinates returns an error if lat or lon is out of range.
+func ValidateCoordinates(lat, lon float64) error {
+	if lat < -90 || lat > 90 || math.IsNaN(lat) {
+		return fmt.Errorf("latitude %g must be between -90 and 90", lat)
+	}
+	if lon < -180 || lon > 180 || math.IsNaN(lon) {
+		return fmt.Errorf("longitude %g must be between -180 and 180", lon)
+	}
+	return nil
+}
+
+// Haversine returns the great-circle distance in kilometers between two
+// points given in degrees.
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180.0
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// ObservationCoordinates returns the latitude and longitude of the station
+// an observation came from, or false if the response has no geometry.
+func ObservationCoordinates(r ObservationResponse) (lat, lon float64, ok bool) {
+	if len(r.Geometry.Coordinates) < 2 {
+		return 0, 0, false
+	}
+	return r.Geometry.Coordinates[1], r.Geometry.Coordinates[0], true
+}
+
+// RetrieveStationDistance returns the distance in kilometers from the given
+// coordinates to the location NWS lists for the station.
+func RetrieveStationDistance(client *http.Client, address, station string, lat, lon float64, timeout time.Duration) (float64, error) {
+	var s StationResponse
+	if err := fetchJSON(client, address, fmt.Sprintf("/stations/%s", station), timeout, &s); err != nil {
+		return 0, err
+	}
+	if len(s.Geometry.Coordinates) < 2 {
+		return 0, fmt.Errorf("no coordinates for station %s", station)
+	}
+	return Haversine(lat, lon, s.Geometry.Coordinates[1], s.Geometry.Coordinates[0]), nil
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
@@ -16,240 +17,415 @@ var (
 	address              string
 	help                 bool
 	verbose              bool
+	logsampling          int
 	timeout, backofftime int
+	mininterval          int
 	failfast             bool
 	localaddr            string
-
-	humidity = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "nws",
-		Name:      "humidity",
-		Help:      "humidity gauge percentage",
-	})
-	temperature = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "nws",
-		Name:      "temperature",
-		Help:      "temperature in celsius",
-	})
-	dewpoint = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "nws",
-		Name:      "dewpoint",
-		Help:      "dewpoint in celsius",
-	})
-	winddirection = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "nws",
-			Name:      "wind_direction",
-			Help:      "wind direction in degrees",
-		},
-		[]string{"Direction"},
-	)
-	windspeed = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "nws",
-		Name:      "wind_speed",
-		Help:      "wind speed in kilometers per hour",
-	})
-	barometricpressure = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "nws",
-		Name:      "barometric_pressure",
-		Help:      "barometric pressure in pascals",
-	})
-	sealevelpressure = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "nws",
-		Name:      "sealevel_pressure",
-		Help:      "sealevel pressure in pascals",
-	})
-	visibility = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "nws",
-		Name:      "visibility",
-		Help:      "visibility in meters",
-	})
-	cloudcover = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "nws",
-			Name:      "cloud_cover",
-			Help:      "cloud cover amount and base height in meters",
-		},
-		[]string{"amount"},
-	)
-	sunAltitude = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sun",
-		Name:      "altitude",
-		Help:      "sun altitude in degrees above horizon (negative = below horizon)",
-	})
-	sunAzimuth = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sun",
-		Name:      "azimuth",
-		Help:      "sun azimuth in degrees from North (0=N, 90=E, 180=S, 270=W)",
-	})
-	sunIsDaylight = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sun",
-		Name:      "is_daylight",
-		Help:      "1 if sun is above horizon, 0 if below",
-	})
-	sunSunrise = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sun",
-		Name:      "sunrise_time",
-		Help:      "today's sunrise time as Unix timestamp",
-	})
-	sunSunset = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sun",
-		Name:      "sunset_time",
-		Help:      "today's sunset time as Unix timestamp",
-	})
+	sourceaddr           string
+	insecure             bool
+	uselist              bool
+	dnstimeout           int
+	units                string
+	rangespec            string
+	namespace            string
+	sunNamespace         string
+	solarNamespace       string
+	obstimestamps        bool
+	nosun                bool
+	smooth               bool
+	smoothfactor         float64
+	smoother             *Smoother
+	zone                 string
+	zoneinterval         int
+	legacynames          bool
+	maxretries           int
+	mirrors              string
+	autotimezone         bool
+	enablereload         bool
+	reloadtoken          string
+	maxresponsebytes     int64
+	observationtimeout   int
+	metadatatimeout      int
+	once                 bool
+	pullmode             bool
+	quiet                bool
+	compasspoints        int
+	dumpraw              bool
+	metricnames          string
+	webhook              string
+	fieldmap             string
+	fieldMap             map[string]string
+	healthcheck          bool
+	debugmetrics         bool
+	maxpanics            int
+	autodetect           bool
+	temperaturebuckets   string
+	source               string
+	onmissing            string
+	jitter               float64
+	timeoffset           time.Duration
+	deadband             string
+	precision            string
+	acceptlanguage       string
+	maxstationdistance   float64
+	strict               bool
+	forecast             bool
+	pinsha256            string
+	maxfallbackdistance  float64
+	datalog              string
+	datalogmaxbytes      int64
+	dataLog              *DataLog
+	historyhours         int
+	maxfailures          int
+	keepalive            int
+	perstation           bool
 )
 
 func init() {
 	flag.StringVar(&station, "station", "KPHL", "nws address")
-	flag.StringVar(&localaddr, "localaddr", ":8080", "The address to listen on for HTTP requests")
+	flag.StringVar(&localaddr, "localaddr", ":8080", "Comma separated addresses to listen on for HTTP requests")
+	flag.StringVar(&sourceaddr, "sourceaddr", "", "Local IP address to bind outbound requests to (default: OS chooses)")
 	flag.StringVar(&address, "addr", "api.weather.gov", "nws address")
+	flag.BoolVar(&uselist, "uselist", false, "Use the newest entry from the observation list instead of the latest endpoint")
+	flag.BoolVar(&insecure, "insecure", false, "Skip TLS certificate verification for -addr and -mirrors, not the webhook or geolocation (dangerous, only for internal proxies)")
 	flag.BoolVar(&help, "help", false, "help info")
 	flag.BoolVar(&verbose, "verbose", false, "verbose logging")
+	flag.IntVar(&logsampling, "logsampling", 1, "With -verbose, only log every Nth successful scrape")
 	flag.IntVar(&timeout, "timeout", 10, "timeout in seconds")
+	flag.IntVar(&dnstimeout, "dnstimeout", 5, "DNS lookup timeout in seconds, 0 for no limit beyond -timeout")
 	flag.IntVar(&backofftime, "backofftime", 100, "backofftime in seconds")
+	flag.IntVar(&mininterval, "mininterval", 60, "minimum allowed backofftime in seconds, to avoid hammering the NWS api")
 	flag.BoolVar(&failfast, "failfast", false, "Exit quickly on errors")
-	flag.Parse()
-	prometheus.MustRegister(humidity)
-	prometheus.MustRegister(temperature)
-	prometheus.MustRegister(dewpoint)
-	prometheus.MustRegister(winddirection)
-	prometheus.MustRegister(windspeed)
-	prometheus.MustRegister(barometricpressure)
-	prometheus.MustRegister(sealevelpressure)
-	prometheus.MustRegister(visibility)
-	prometheus.MustRegister(cloudcover)
-	prometheus.MustRegister(sunAltitude)
-	prometheus.MustRegister(sunAzimuth)
-	prometheus.MustRegister(sunIsDaylight)
-	prometheus.MustRegister(sunSunrise)
-	prometheus.MustRegister(sunSunset)
+	flag.StringVar(&units, "units", unitsMetric, "Units to export observations in, metric or imperial")
+	flag.StringVar(&rangespec, "ranges", "", "Comma separated plausible range overrides, e.g. temperature=-50:50")
+	flag.StringVar(&namespace, "namespace", "nws", "Namespace for observation metrics")
+	flag.StringVar(&sunNamespace, "sunnamespace", "sun", "Namespace for sun position metrics")
+	flag.StringVar(&solarNamespace, "solarnamespace", "solar", "Namespace for the solar irradiance estimate")
+	flag.BoolVar(&obstimestamps, "obstimestamps", false, "Export observation metrics with the observation's timestamp instead of the scrape time")
+	flag.BoolVar(&nosun, "nosun", false, "Disable the sun position metrics")
+	flag.BoolVar(&smooth, "smooth", false, "Also export exponentially smoothed temperature, humidity, dewpoint, wind speed and pressure")
+	flag.Float64Var(&smoothfactor, "smoothfactor", 0.3, "Smoothing factor for -smooth, between 0 and 1 (lower is smoother)")
+	flag.StringVar(&zone, "zone", "", "NWS forecast zone, e.g. HIZ017, to export mean temperature and humidity for")
+	flag.IntVar(&zoneinterval, "zoneinterval", 600, "Interval in seconds between polls of the -zone stations")
+	flag.BoolVar(&legacynames, "legacynames", false, "Use the old metric names without unit suffixes")
+	flag.IntVar(&maxretries, "maxretries", 0, "Number of times to retry a failed station request within a scrape")
+	flag.StringVar(&mirrors, "mirrors", "", "Comma separated nws mirror addresses to try in order if -addr can't be reached")
+	flag.BoolVar(&autotimezone, "autotimezone", false, "Look up the local timezone for the sun coordinates from the NWS points api, falling back to the system timezone if that fails")
+	flag.BoolVar(&enablereload, "enablereload", false, "Serve POST /reload to switch the station and sun coordinates without restarting")
+	flag.StringVar(&reloadtoken, "reloadtoken", "", "Bearer token required by /reload, which otherwise only accepts requests from loopback addresses")
+	flag.Int64Var(&maxresponsebytes, "maxresponsebytes", 1<<20, "Fail observation requests whose response body is larger than this many bytes, 0 for no limit")
+	flag.IntVar(&observationtimeout, "observationtimeout", 0, "timeout in seconds for observation requests (default -timeout)")
+	flag.IntVar(&metadatatimeout, "metadatatimeout", 0, "timeout in seconds for station, zone and timezone metadata requests (default -timeout)")
+	flag.BoolVar(&pullmode, "pullmode", false, "Scrape NWS when /metrics is requested, at most every -mininterval, instead of every -backofftime in the background")
+	flag.BoolVar(&once, "once", false, "Scrape once and exit with 0 if complete, 1 on failure or 2 on partial data, instead of serving")
+	flag.BoolVar(&quiet, "quiet", false, "Suppress all log output")
+	flag.IntVar(&compasspoints, "compasspoints", 4, "Number of compass points for wind and sun direction labels, 4, 8, 16 or 32")
+	flag.BoolVar(&dumpraw, "dumpraw", false, "Log the first 4KB of every raw observation response before parsing it")
+	flag.StringVar(&metricnames, "metrics", "", "Comma separated full names of the only metrics to export, e.g. nws_temperature_celsius; the rest are still computed, only left out when served (default all)")
+	flag.StringVar(&webhook, "webhook", "", "URL to POST a JSON event to at sunrise and sunset")
+	flag.StringVar(&fieldmap, "fieldmap", "", "JSON file renaming observation properties, e.g. {\"airTemperature\": \"temperature\"}")
+	flag.BoolVar(&healthcheck, "healthcheck", false, "Check the health of the exporter listening on the first -localaddr and exit 0 if healthy, 1 if not")
+	flag.BoolVar(&debugmetrics, "debugmetrics", false, "Also export the Julian day and local sidereal time used for the sun position")
+	flag.IntVar(&maxpanics, "maxpanics", 5, "Exit after more than this many scrape panics within an hour, 0 to never exit")
+	flag.BoolVar(&autodetect, "autodetect", false, "Geolocate our public IP address to pick the sun coordinates unless -latitude or -longitude is given, and the nearest station unless -station is given")
+	flag.StringVar(&temperaturebuckets, "temperaturebuckets", "", "Comma separated bucket bounds for the temperature distribution histogram, in the exported units (default -30 to 40 by 5 celsius, or -20 to 100 by 10 fahrenheit)")
+	flag.StringVar(&source, "source", sourceGeoJSON, "Where to get observations, geojson for the NWS api or metar for raw METARs from aviationweather.gov")
+	flag.StringVar(&onmissing, "onmissing", missingKeep, "What to export when an observation lacks a reading: keep the last value, zero, nan, or clear the series")
+	flag.Float64Var(&daylightAngle, "daylightangle", daylightAngle, "Sun altitude in degrees above which sun_is_daylight is 1, e.g. -6 for civil twilight")
+	flag.Float64Var(&jitter, "jitter", 5, "Randomly spread the startup and each scrape interval by up to this percent of backofftime, 0 to disable")
+	flag.DurationVar(&timeoffset, "timeoffset", 0, "TESTING ONLY: shift the clock used for the sun position and webhook by this duration, e.g. 6h")
+	flag.StringVar(&precision, "precision", "", "Comma separated decimal places to round readings to, in the exported units, either for every reading or per reading, e.g. 1,barometric_pressure=-1")
+	flag.StringVar(&deadband, "deadband", "", "Comma separated minimum changes to apply, in the exported units, e.g. temperature=0.1,barometric_pressure=10")
+	flag.StringVar(&acceptlanguage, "acceptlanguage", "en-US", "Accept-Language header sent to NWS for localized text descriptions, empty to send none")
+	flag.Float64Var(&latitude, "latitude", latitude, "Latitude in degrees north for the sun metrics")
+	flag.Float64Var(&longitude, "longitude", longitude, "Longitude in degrees east for the sun metrics")
+	flag.Float64Var(&maxstationdistance, "maxstationdistance", 0, "Warn at startup if the station is more than this many kilometers from -latitude and -longitude, e.g. 100 (default 0, no check)")
+	flag.BoolVar(&strict, "strict", false, "Exit instead of warning when the station is further than -maxstationdistance")
+	flag.BoolVar(&forecast, "forecast", false, "Export the forecast daily high and low temperatures for the sun coordinates")
+	flag.StringVar(&pinsha256, "pinsha256", "", "Comma separated base64 SHA-256 hashes of the public keys -addr and -mirrors must present, e.g. from openssl (default no pinning)")
+	flag.Float64Var(&maxfallbackdistance, "maxfallbackdistance", 0, "Skip fallback stations more than this many kilometers from the primary station, 0 for no limit")
+	flag.StringVar(&datalog, "datalog", "", "Append each new observation and sun position as a JSON line to this file, rotated daily (default off)")
+	flag.Int64Var(&datalogmaxbytes, "datalogmaxbytes", 10<<20, "Also rotate the -datalog file when it would grow past this many bytes, 0 for daily rotation only")
+	flag.IntVar(&historyhours, "historyhours", 0, "Fetch this many hours of the station's recent observations with each new one, to export the pressure change over them, 0 to disable")
+	flag.Float64Var(&panelTilt, "paneltilt", panelTilt, "Tilt of a fixed solar panel in degrees from horizontal, for sun_angle_of_incidence_degrees")
+	flag.Float64Var(&panelAzimuth, "panelazimuth", panelAzimuth, "Direction a fixed solar panel faces in degrees from North, for sun_angle_of_incidence_degrees")
+	flag.IntVar(&maxfailures, "maxconsecutivefailures", 0, "Exit after this many scrapes in a row fail, so an orchestrator can restart the exporter, 0 to never exit")
+	flag.IntVar(&keepalive, "keepalive", 0, "Send a HEAD request to -addr every this many seconds to keep the connection warm between scrapes, 0 to disable")
+	flag.BoolVar(&perstation, "perstationpaths", false, "Also serve the readings of each retrieved station, primary, fallback or zone, from its own registry at /metrics/<station>")
 }
 
 func main() {
+	// Flags are parsed here rather than in init so that tests, which have
+	// flags of their own, can build the package and register the metrics
+	// themselves.
+	flag.Parse()
+	var buckets []float64
+	if temperaturebuckets != "" {
+		var err error
+		if buckets, err = ParseBuckets(temperaturebuckets); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+	registerMetrics(MetricsOptions{
+		Namespace:             namespace,
+		SunNamespace:          sunNamespace,
+		SolarNamespace:        solarNamespace,
+		ObservationTimestamps: obstimestamps,
+		NoSun:                 nosun,
+		Zone:                  zone != "",
+		Forecast:              forecast,
+		History:               historyhours > 0,
+		Smooth:                smooth,
+		Units:                 units,
+		LegacyNames:           legacynames,
+		DebugMetrics:          debugmetrics,
+		PerStation:            perstation,
+		TemperatureBuckets:    buckets,
+	})
+
 	if help {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	log.Printf("Starting up, retrieving from %s at station %s", address, station)
-	log.Printf("Serving on http://%s/metrics...", localaddr)
-	// start scrape loop
-	go func() {
-		for {
-			// Always try primary station first
-			primaryResponse, primaryErr := RetrieveCurrentObservation(station, address, timeout)
-			
-			// Try fallback stations if needed: PHHN (Hana), PHLI (Lihue)
-			fallbackStations := []string{"PHHN", "PHLI"}
-			var fallbackResponse ObservationResponse
-			var fallbackErr error
-			fallbackUsed := false
-			
-			// Check if we need fallback data (primary has null temperature)
-			if primaryErr != nil || primaryResponse.Properties.Temperature.Value == 0 {
-				for _, tryStation := range fallbackStations {
-					fallbackResponse, fallbackErr = RetrieveCurrentObservation(tryStation, address, timeout)
-					if fallbackErr == nil && fallbackResponse.Properties.Temperature.Value != 0 {
-						log.Printf("Using fallback station %s for missing data from %s", tryStation, station)
-						fallbackUsed = true
-						break
-					}
-				}
-			}
-			
-			if primaryErr != nil && (!fallbackUsed || fallbackErr != nil) {
-				if failfast {
-					log.Fatalf("error: %v", primaryErr)
-				}
-
-				log.Printf("Problem retrieving from all stations: %v", primaryErr)
-				backoffseconds := (time.Duration(backofftime) * time.Second)
-				log.Printf("Waiting %v seconds, next scrape at %s", backofftime, time.Now().Add(backoffseconds))
-				time.Sleep(time.Duration(backofftime) * time.Second)
-				continue
-			}
-			
-			// Helper function to get value from primary or fallback
-			getValue := func(primaryVal, fallbackVal float64) float64 {
-				if primaryErr == nil && primaryVal != 0 {
-					return primaryVal
-				}
-				if fallbackUsed && fallbackVal != 0 {
-					return fallbackVal
-				}
-				return 0
-			}
-			
-			// Set metrics, preferring primary station data
-			if val := getValue(primaryResponse.Properties.RelativeHumidity.Value, fallbackResponse.Properties.RelativeHumidity.Value); val != 0 {
-				humidity.Set(val)
-			}
-			if val := getValue(primaryResponse.Properties.Temperature.Value, fallbackResponse.Properties.Temperature.Value); val != 0 {
-				temperature.Set(val)
-			}
-			if val := getValue(primaryResponse.Properties.Dewpoint.Value, fallbackResponse.Properties.Dewpoint.Value); val != 0 {
-				dewpoint.Set(val)
-			}
-			if val := getValue(primaryResponse.Properties.WindDirection.Value, fallbackResponse.Properties.WindDirection.Value); val != 0 {
-				winddirection.WithLabelValues(CardinalDirection(val)).Set(val)
-			}
-			if val := getValue(primaryResponse.Properties.WindSpeed.Value, fallbackResponse.Properties.WindSpeed.Value); val != 0 {
-				windspeed.Set(val)
-			}
-			if val := getValue(primaryResponse.Properties.BarometricPressure.Value, fallbackResponse.Properties.BarometricPressure.Value); val != 0 {
-				barometricpressure.Set(val)
-			}
-			if val := getValue(primaryResponse.Properties.SeaLevelPressure.Value, fallbackResponse.Properties.SeaLevelPressure.Value); val != 0 {
-				sealevelpressure.Set(val)
-			}
-			if val := getValue(primaryResponse.Properties.Visibility.Value, fallbackResponse.Properties.Visibility.Value); val != 0 {
-				visibility.Set(val)
-			}
-			
-			// Cloud cover - always prefer primary station (PHOG)
-			if primaryErr == nil && len(primaryResponse.Properties.CloudLayers) > 0 {
-				for _, layer := range primaryResponse.Properties.CloudLayers {
-					baseHeight := 0.0
-					if layer.Base.Value != 0 {
-						baseHeight = float64(layer.Base.Value)
-					}
-					cloudcover.WithLabelValues(layer.Amount).Set(baseHeight)
-				}
-			} else if fallbackUsed && len(fallbackResponse.Properties.CloudLayers) > 0 {
-				for _, layer := range fallbackResponse.Properties.CloudLayers {
-					baseHeight := 0.0
-					if layer.Base.Value != 0 {
-						baseHeight = float64(layer.Base.Value)
-					}
-				cloudcover.WithLabelValues(layer.Amount).Set(baseHeight)
-				}
-			}
-			
-			// Calculate and set sun position
-			sunPos := CalculateSunPosition(time.Now())
-			sunAltitude.Set(sunPos.Altitude)
-			sunAzimuth.Set(sunPos.Azimuth)
-			if sunPos.IsDaylight {
-				sunIsDaylight.Set(1)
-			} else {
-				sunIsDaylight.Set(0)
-			}
-			if !sunPos.Sunrise.IsZero() {
-				sunSunrise.Set(float64(sunPos.Sunrise.Unix()))
+	if quiet {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if healthcheck {
+		if err := HealthCheck(strings.Split(localaddr, ",")[0]); err != nil {
+			log.Printf("unhealthy: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if err := ValidateStation(station); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if backofftime < mininterval {
+		log.Printf("Warning: backofftime %ds is below the minimum of %ds, using %ds", backofftime, mininterval, mininterval)
+		backofftime = mininterval
+	}
+
+	if err := ValidateJitter(jitter); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if err := ValidateUnits(units); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if err := ValidateCoordinates(latitude, longitude); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if err := ValidateCompassPoints(compasspoints); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if err := ValidateSource(source); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if err := ValidateMissing(onmissing); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if zone != "" && zoneinterval < 1 {
+		log.Fatalf("error: -zoneinterval %d must be at least 1 second", zoneinterval)
+	}
+
+	ranges, err := ParseRanges(rangespec)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if deadband != "" {
+		if err := ParseDeadbands(deadband); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	if precision != "" {
+		if err := ParsePrecision(precision); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	if fieldmap != "" {
+		fieldMap, err = LoadFieldMap(fieldmap)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	if smooth {
+		smoother, err = NewSmoother(smoothfactor)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	// The per-endpoint timeouts are applied to each request, so the client's
+	// own timeout must not cut the longest of them short
+	clientTimeout := timeout
+	for _, t := range []int{observationtimeout, metadatatimeout} {
+		if t > clientTimeout {
+			clientTimeout = t
+		}
+	}
+	var pins, mirrorList []string
+	if pinsha256 != "" {
+		pins = strings.Split(pinsha256, ",")
+	}
+	if mirrors != "" {
+		mirrorList = strings.Split(mirrors, ",")
+	}
+	nwsHosts := append([]string{address}, mirrorList...)
+	client, err := NewClient(ClientOptions{
+		Timeout:        clientTimeout,
+		DNSTimeout:     dnstimeout,
+		SourceAddr:     sourceaddr,
+		Insecure:       insecure,
+		InsecureHosts:  nwsHosts,
+		AcceptLanguage: acceptlanguage,
+		PinSHA256:      pins,
+		PinHosts:       nwsHosts,
+		KeepAlive:      keepalive,
+	})
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if timeoffset != 0 {
+		log.Printf("Warning: sun metrics use a clock shifted by %v, for testing only", timeoffset)
+	}
+
+	if insecure {
+		log.Printf("Warning: TLS certificate verification is disabled for %s", strings.Join(nwsHosts, ", "))
+	}
+
+	if autodetect {
+		// Only autodetect what wasn't given explicitly
+		set := setFlags(flag.CommandLine)
+		if set["latitude"] || set["longitude"] {
+			log.Printf("Using -latitude and -longitude rather than autodetecting coordinates")
+		} else {
+			lat, lon, err := GeolocateIP(client)
+			if err != nil {
+				log.Fatalf("error: could not geolocate: %v", err)
 			}
-			if !sunPos.Sunset.IsZero() {
-				sunSunset.Set(float64(sunPos.Sunset.Unix()))
+			latitude, longitude = lat, lon
+			log.Printf("Autodetected coordinates %.4f,%.4f", latitude, longitude)
+		}
+
+		if !set["station"] {
+			nearest, err := RetrieveNearestStation(client, address, latitude, longitude, endpointTimeout(metadatatimeout))
+			if err != nil {
+				log.Fatalf("error: could not find a station near %.4f,%.4f: %v", latitude, longitude, err)
 			}
-			
-			if verbose {
-				log.Printf("Sun: alt=%.1f°, az=%.1f°, daylight=%v", sunPos.Altitude, sunPos.Azimuth, sunPos.IsDaylight)
-				log.Printf("Sunrise: %s, Sunset: %s", sunPos.Sunrise.Format("2006-01-02 15:04 MST"), sunPos.Sunset.Format("2006-01-02 15:04 MST"))
-				log.Printf("Waiting %v seconds, next scrape at %s", backofftime, time.Now().Add(
-					time.Duration(backofftime)*time.Second).String())
+			station = nearest
+			log.Printf("Autodetected nearest station %s", station)
+		}
+	}
+
+	if autotimezone {
+		loc, err := RetrieveTimezone(client, address, latitude, longitude, endpointTimeout(metadatatimeout))
+		if err != nil {
+			log.Printf("Warning: could not look up timezone, using the system timezone: %v", err)
+			loc = time.Local
+		}
+		localZone = loc
+		log.Printf("Using timezone %s for sun times", localZone)
+	}
+
+	// Opened once the timezone is known, since it rotates on local days
+	if datalog != "" {
+		dataLog, err = NewDataLog(datalog, datalogmaxbytes)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	if maxstationdistance > 0 {
+		distance, err := RetrieveStationDistance(client, address, station, latitude, longitude, endpointTimeout(metadatatimeout))
+		if err != nil {
+			log.Printf("Warning: could not check the location of station %s: %v", station, err)
+		} else if distance > maxstationdistance {
+			if strict {
+				log.Fatalf("error: station %s is %.0fkm from %.4f,%.4f, more than -maxstationdistance %.0fkm", station, distance, latitude, longitude, maxstationdistance)
 			}
-			time.Sleep(time.Duration(backofftime) * time.Second)
+			log.Printf("Warning: station %s is %.0fkm from %.4f,%.4f, the sun metrics may not match its weather", station, distance, latitude, longitude)
 		}
-	}()
+	}
+
+	if once {
+		os.Exit(scrapeOnce(client, ranges))
+	}
+
+	if webhook != "" {
+		go watchSunEvents(client, webhook)
+	}
+
+	if zone != "" {
+		go watchZone(client, zone, time.Duration(zoneinterval)*time.Second)
+	}
+
+	if keepalive > 0 {
+		go keepWarm(client, address, time.Duration(keepalive)*time.Second)
+	}
+
+	if delay := startupDelay(); delay > 0 {
+		log.Printf("Delaying startup by %v", delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+
+	log.Printf("Starting up, retrieving from %s at station %s", address, station)
+	started := startupScrape(client, ranges)
+	if !started && failfast {
+		log.Fatalf("error: no station could be retrieved at startup")
+	}
+	listenAddrs := strings.Split(localaddr, ",")
+	for _, addr := range listenAddrs {
+		log.Printf("Serving on http://%s/metrics...", addr)
+	}
+	// start scrape loop, unless -pullmode scrapes when /metrics is requested
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if pullmode {
+		log.Printf("Scraping when /metrics is requested, at most every %ds", mininterval)
+		gatherer = newPullGatherer(client, ranges, time.Duration(mininterval)*time.Second)
+	} else {
+		go superviseScrapeLoop(client, ranges, started)
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(localaddr, nil))
+	var allow []string
+	if metricnames != "" {
+		allow = strings.Split(metricnames, ",")
+	}
+	http.Handle("/metrics", metricsHandler(gatherer, allow))
+	http.HandleFunc("/", statusHandler)
+	http.HandleFunc("/sun", sunHandler)
+	http.HandleFunc("/api/conditions", conditionsHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/config", configHandler)
+	if perstation {
+		http.HandleFunc("/metrics/", stationMetricsHandler(allow))
+	}
+	if enablereload {
+		http.HandleFunc("/reload", reloadHandler)
+	}
+	log.Fatal(ListenAndServeAll(listenAddrs, http.DefaultServeMux))
+}
+
+// setFlags returns the names of the flags in fs that were set on the command
+// line, as opposed to left at their defaults.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+// TestMain registers the metrics with their flag defaults, as main does
+// after parsing flags, so that the code under test can set them.
+func TestMain(m *testing.M) {
+	registerMetrics(MetricsOptions{
+		Namespace:      namespace,
+		SunNamespace:   sunNamespace,
+		SolarNamespace: solarNamespace,
+		Units:          units,
+	})
+	os.Exit(m.Run())
+}
+
+func TestSetFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Float64("latitude", 20.8986, "")
+	fs.Float64("longitude", -156.4306, "")
+	fs.String("station", "KPHL", "")
+	if err := fs.Parse([]string{"-latitude", "39.87", "-station=KPHL"}); err != nil {
+		t.Fatal(err)
+	}
+	set := setFlags(fs)
+	if !set["latitude"] || set["longitude"] || !set["station"] {
+		t.Errorf("setFlags = %v, want latitude and station", set)
+	}
+}
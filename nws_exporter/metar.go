@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Observation sources accepted by the -source flag.
+const (
+	sourceGeoJSON = "geojson"
+	sourceMETAR   = "metar"
+)
+
+// metarAddress is the aviation weather center api raw METARs are fetched
+// from.
+const metarAddress = "aviationweather.gov"
+
+// ValidateSource returns an error if source is not one we can retrieve
+// observations from.
+func ValidateSource(source string) error {
+	switch source {
+	case sourceGeoJSON, sourceMETAR:
+		return nil
+	default:
+		return fmt.Errorf("unknown source %q, expected %q or %q", source, sourceGeoJSON, sourceMETAR)
+	}
+}
+
+// RetrieveMETARObservation fetches the latest raw METAR for station from the
+// aviation weather center api at address, normally metarAddress, and parses
+// it into an ObservationResponse in the same units the NWS api uses.
+func RetrieveMETARObservation(client *http.Client, station string, address string, opts ObservationOptions) (ObservationResponse, error) {
+	requestURL := url.URL{
+		Scheme:   "https",
+		Host:     address,
+		Path:     "/api/data/metar",
+		RawQuery: url.Values{"ids": {station}, "format": {"raw"}}.Encode(),
+	}
+	req, err := http.NewRequest("GET", requestURL.String(), nil)
+	if err != nil {
+		return ObservationResponse{}, err
+	}
+	req, cancel := withTimeout(req, opts.Timeout)
+	defer cancel()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ObservationResponse{}, err
+	}
+	defer resp.Body.Close()
+	httpResponses.WithLabelValues(strconv.Itoa(resp.StatusCode), station).Inc()
+
+	if resp.StatusCode != 200 {
+		return ObservationResponse{}, newStatusError(resp)
+	}
+
+	body, err := readBody(resp, opts)
+	if err != nil {
+		return ObservationResponse{}, err
+	}
+
+	raw := strings.TrimSpace(strings.SplitN(strings.TrimSpace(string(body)), "\n", 2)[0])
+	if raw == "" {
+		return ObservationResponse{}, fmt.Errorf("no METAR for %s", station)
+	}
+	return ParseMETAR(raw, time.Now())
+}
+
+var (
+	metarTimePattern        = regexp.MustCompile(`^(\d{2})(\d{2})(\d{2})Z$`)
+	metarWindPattern        = regexp.MustCompile(`^(\d{3}|VRB)(\d{2,3})(?:G\d{2,3})?(KT|MPS)$`)
+	metarVisibilityPattern  = regexp.MustCompile(`^[PM]?(\d+)(?:/(\d+))?SM$`)
+	metarCloudPattern       = regexp.MustCompile(`^(FEW|SCT|BKN|OVC|VV)(\d{3})`)
+	metarTemperaturePattern = regexp.MustCompile(`^(M?\d{2})/(M?\d{2})?$`)
+	metarPressurePattern    = regexp.MustCompile(`^([AQ])(\d{4})$`)
+	// The remarks' T group gives temperature and dewpoint to a tenth of a
+	// degree, e.g. T02440183 is 24.4 and 18.3, with a leading 1 for negative
+	metarPreciseTempPattern = regexp.MustCompile(`^T([01])(\d{3})([01])(\d{3})$`)
+	metarPrecipPattern      = regexp.MustCompile(`^P(\d{4})$`)
+)
+
+// ParseMETAR parses a raw METAR report, e.g.
+// "PHOG 160554Z 06012KT 10SM FEW025 24/18 A3002 RMK AO2 T02440183", into
+// an ObservationResponse. now resolves the report's day of month to a date.
+// Readings the report doesn't include are left zero, as with the NWS api.
+func ParseMETAR(raw string, now time.Time) (ObservationResponse, error) {
+	var o ObservationResponse
+	p := &o.Properties
+	p.RawMessage = raw
+
+	fields := strings.Fields(raw)
+	if len(fields) > 0 && (fields[0] == "METAR" || fields[0] == "SPECI") {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return o, fmt.Errorf("invalid METAR %q", raw)
+	}
+	p.Station = fields[0]
+
+	m := metarTimePattern.FindStringSubmatch(fields[1])
+	if m == nil {
+		return o, fmt.Errorf("invalid METAR time %q", fields[1])
+	}
+	day, _ := strconv.Atoi(m[1])
+	hour, _ := strconv.Atoi(m[2])
+	minute, _ := strconv.Atoi(m[3])
+	now = now.UTC()
+	year, month := now.Year(), now.Month()
+	// A report from late last month seen early this month. AddDate would
+	// normalize a day the previous month doesn't have, e.g. the 30th on
+	// March 1st, forward into this month, so the month is stepped back by
+	// taking the date of the day before the 1st
+	if day > daysIn(year, month) || time.Date(year, month, day, hour, minute, 0, 0, time.UTC).After(now.Add(24*time.Hour)) {
+		year, month, _ = time.Date(year, month, 0, 0, 0, 0, 0, time.UTC).Date()
+	}
+	if day > daysIn(year, month) {
+		return o, fmt.Errorf("invalid METAR day %d for %s %d", day, month, year)
+	}
+	p.Timestamp = time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+
+	remarks := false
+	wholeMiles := 0.0
+	for _, field := range fields[2:] {
+		if field == "RMK" {
+			remarks = true
+			continue
+		}
+		if remarks {
+			if m := metarPreciseTempPattern.FindStringSubmatch(field); m != nil {
+				p.Temperature.Value = metarTenths(m[1], m[2])
+				p.Dewpoint.Value = metarTenths(m[3], m[4])
+			} else if m := metarPrecipPattern.FindStringSubmatch(field); m != nil {
+				hundredths, _ := strconv.Atoi(m[1])
+				mm := float64(hundredths) * 0.254
+				p.PrecipitationLastHour.Value = &mm
+			}
+			continue
+		}
+
+		if m := metarWindPattern.FindStringSubmatch(field); m != nil {
+			speed, _ := strconv.ParseFloat(m[2], 64)
+			if m[3] == "KT" {
+				speed *= 1.852
+			} else {
+				speed *= 3.6
+			}
+			p.WindSpeed.Value = &speed
+			// Calm (00000KT) and variable winds have no direction
+			if m[1] != "VRB" && (m[1] != "000" || speed != 0) {
+				direction, _ := strconv.ParseFloat(m[1], 64)
+				p.WindDirection.Value = &direction
+			}
+		} else if n, err := strconv.Atoi(field); err == nil && len(field) == 1 {
+			// The whole miles of a visibility such as 1 1/2SM
+			wholeMiles = float64(n)
+		} else if m := metarVisibilityPattern.FindStringSubmatch(field); m != nil {
+			miles, _ := strconv.ParseFloat(m[1], 64)
+			if m[2] != "" {
+				denominator, _ := strconv.ParseFloat(m[2], 64)
+				miles = wholeMiles + miles/denominator
+			}
+			p.Visibility.Value = miles * 1609.344
+		} else if len(field) == 4 && metarAllDigits(field) {
+			// Visibility in meters, with 9999 meaning 10km or more
+			meters, _ := strconv.ParseFloat(field, 64)
+			p.Visibility.Value = meters
+		} else if field == "CAVOK" {
+			// Clear skies have no cloud layers, as in the NWS api
+			p.Visibility.Value = 10000
+		} else if field == "CLR" || field == "SKC" || field == "NSC" || field == "NCD" {
+			continue
+		} else if m := metarCloudPattern.FindStringSubmatch(field); m != nil {
+			hundredsFeet, _ := strconv.Atoi(m[2])
+			var layer CloudLayer
+			layer.Amount = m[1]
+			layer.Base.Value = int(float64(hundredsFeet) * 100 * 0.3048)
+			layer.Base.UnitCode = "wmoUnit:m"
+			p.CloudLayers = append(p.CloudLayers, layer)
+		} else if m := metarTemperaturePattern.FindStringSubmatch(field); m != nil {
+			p.Temperature.Value = metarWhole(m[1])
+			if m[2] != "" {
+				p.Dewpoint.Value = metarWhole(m[2])
+			}
+		} else if m := metarPressurePattern.FindStringSubmatch(field); m != nil {
+			// The altimeter setting is the closest a METAR comes to sea
+			// level pressure
+			value, _ := strconv.ParseFloat(m[2], 64)
+			if m[1] == "A" {
+				p.SeaLevelPressure.Value = value / 100 * 3386.389
+			} else {
+				p.SeaLevelPressure.Value = value * 100
+			}
+		}
+	}
+
+	if p.Temperature.Value != 0 && p.Dewpoint.Value != 0 {
+		p.RelativeHumidity.Value = RelativeHumidity(p.Temperature.Value, p.Dewpoint.Value)
+	}
+	return o, nil
+}
+
+// daysIn returns the number of days in the given month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// metarWhole parses a whole degree METAR temperature such as 24 or M02.
+func metarWhole(s string) float64 {
+	n, _ := strconv.ParseFloat(strings.TrimPrefix(s, "M"), 64)
+	if strings.HasPrefix(s, "M") {
+		return -n
+	}
+	return n
+}
+
+// metarTenths parses a remarks temperature in tenths of a degree, where sign
+// is 1 for negative.
+func metarTenths(sign, tenths string) float64 {
+	n, _ := strconv.ParseFloat(tenths, 64)
+	if sign == "1" {
+		return -n / 10
+	}
+	return n / 10
+}
+
+// metarAllDigits reports whether s is entirely decimal digits.
+func metarAllDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
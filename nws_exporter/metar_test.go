@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetrieveMETARObservationLimits(t *testing.T) {
+	const report = "PHOG 211154Z 05012KT 10SM FEW030 26/18 A3005"
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, report)
+	}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "https://")
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	o, err := RetrieveMETARObservation(srv.Client(), "PHOG", address, ObservationOptions{MaxResponseBytes: int64(len(report) + 1), DumpRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Properties.RawMessage != report {
+		t.Errorf("raw message = %q, want %q", o.Properties.RawMessage, report)
+	}
+	if !strings.Contains(logged.String(), report) {
+		t.Errorf("-dumpraw logged %q, want the raw METAR", logged.String())
+	}
+
+	_, err = RetrieveMETARObservation(srv.Client(), "PHOG", address, ObservationOptions{MaxResponseBytes: int64(len(report))})
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("err = %v, want the response to exceed the limit", err)
+	}
+}
+
+func TestParseMETARClearSkies(t *testing.T) {
+	now := time.Date(2024, time.June, 21, 12, 0, 0, 0, time.UTC)
+	for _, code := range []string{"CLR", "SKC", "NSC", "NCD", "CAVOK"} {
+		o, err := ParseMETAR("PHOG 211154Z 05012KT 10SM "+code+" 26/18 A3005", now)
+		if err != nil {
+			t.Fatalf("%s: %v", code, err)
+		}
+		if len(o.Properties.CloudLayers) != 0 {
+			t.Errorf("%s: cloud layers = %v, want none", code, o.Properties.CloudLayers)
+		}
+	}
+
+	o, err := ParseMETAR("PHOG 211154Z 05012KT 10SM FEW030 SCT050 26/18 A3005", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Properties.CloudLayers) != 2 || o.Properties.CloudLayers[1].Amount != "SCT" {
+		t.Errorf("cloud layers = %v, want FEW and SCT", o.Properties.CloudLayers)
+	}
+}
+
+func TestParseMETARTimestamp(t *testing.T) {
+	tests := []struct {
+		name   string
+		now    time.Time
+		report string
+		want   time.Time
+	}{
+		{"same day", time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC), "010953Z", time.Date(2024, time.March, 1, 9, 53, 0, 0, time.UTC)},
+		{"last month", time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC), "282353Z", time.Date(2024, time.February, 28, 23, 53, 0, 0, time.UTC)},
+		{"leap day", time.Date(2024, time.March, 1, 0, 10, 0, 0, time.UTC), "292353Z", time.Date(2024, time.February, 29, 23, 53, 0, 0, time.UTC)},
+		{"last year", time.Date(2024, time.January, 1, 0, 10, 0, 0, time.UTC), "312353Z", time.Date(2023, time.December, 31, 23, 53, 0, 0, time.UTC)},
+		{"end of a 30 day month", time.Date(2024, time.May, 1, 0, 10, 0, 0, time.UTC), "302353Z", time.Date(2024, time.April, 30, 23, 53, 0, 0, time.UTC)},
+		{"a few minutes ahead", time.Date(2024, time.March, 31, 23, 50, 0, 0, time.UTC), "312353Z", time.Date(2024, time.March, 31, 23, 53, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := ParseMETAR("PHOG "+tt.report+" 05012KT 10SM CLR 26/18 A3005", tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !o.Properties.Timestamp.Equal(tt.want) {
+				t.Errorf("timestamp = %v, want %v", o.Properties.Timestamp, tt.want)
+			}
+		})
+	}
+
+	// February has no 30th, which AddDate normalized to March 2nd
+	now := time.Date(2023, time.March, 1, 10, 0, 0, 0, time.UTC)
+	if o, err := ParseMETAR("PHOG 302353Z 05012KT 10SM CLR 26/18 A3005", now); err == nil {
+		t.Errorf("day 30 on March 1st parsed as %v, want an error", o.Properties.Timestamp)
+	}
+}
@@ -0,0 +1,776 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	humidity           prometheus.Gauge
+	temperature        prometheus.Gauge
+	temperatureMax24h  prometheus.Gauge
+	temperatureMin24h  prometheus.Gauge
+	dewpoint           prometheus.Gauge
+	winddirection      *prometheus.GaugeVec
+	windspeed          prometheus.Gauge
+	windCalm           prometheus.Gauge
+	windU              prometheus.Gauge
+	windV              prometheus.Gauge
+	fieldsPresent      prometheus.Gauge
+	fieldsExpected     prometheus.Gauge
+	barometricpressure prometheus.Gauge
+	sealevelpressure   *prometheus.GaugeVec
+	pressure           *prometheus.GaugeVec
+	pressureSealevel   *prometheus.GaugeVec
+	pressureChange     prometheus.Gauge
+	visibility         prometheus.Gauge
+	visibilityUnlim    prometheus.Gauge
+	cloudcover         *prometheus.GaugeVec
+	precipitation1h    prometheus.Gauge
+	precipitation3h    prometheus.Gauge
+	precipitation6h    prometheus.Gauge
+	cloudlayercount    prometheus.Gauge
+	thswIndex          prometheus.Gauge
+	cloudBase          prometheus.Gauge
+	apparentTemp       prometheus.Gauge
+	temperatureDist    prometheus.Histogram
+	heatIndex          *prometheus.GaugeVec
+	windChill          *prometheus.GaugeVec
+	weatherIcon        *prometheus.GaugeVec
+	conditionCode      *prometheus.GaugeVec
+
+	zoneTemperature       *prometheus.GaugeVec
+	zoneHumidity          *prometheus.GaugeVec
+	zoneStationsReporting *prometheus.GaugeVec
+	forecastHigh          *prometheus.GaugeVec
+	forecastLow           *prometheus.GaugeVec
+	observationCacheHits  prometheus.Counter
+	httpResponses         *prometheus.CounterVec
+
+	humiditySmoothed           prometheus.Gauge
+	temperatureSmoothed        prometheus.Gauge
+	dewpointSmoothed           prometheus.Gauge
+	windspeedSmoothed          prometheus.Gauge
+	barometricpressureSmoothed prometheus.Gauge
+
+	scrapeLoopIterations prometheus.Counter
+	httpPhaseSeconds     *prometheus.HistogramVec
+	observationStale     prometheus.GaugeFunc
+	scrapePanics         prometheus.Counter
+	duplicateObs         prometheus.Counter
+	fallbackFields       *prometheus.GaugeVec
+	reportingStation     *prometheus.GaugeVec
+	sunAltitude          prometheus.Gauge
+	sunAzimuth           prometheus.Gauge
+	sunAzimuthCardinal   *prometheus.GaugeVec
+	sunHourAngle         prometheus.Gauge
+	sunIsDaylight        prometheus.Gauge
+	sunSunrise           prometheus.Gauge
+	sunSunset            prometheus.Gauge
+	sunSecondsToSunrise  prometheus.GaugeFunc
+	sunSecondsToSunset   prometheus.GaugeFunc
+	sunDistanceAU        prometheus.Gauge
+	sunAngularDiam       prometheus.Gauge
+	sunDaysToSolstice    prometheus.Gauge
+	sunAltitudeRate      prometheus.Gauge
+	sunSeason            *prometheus.GaugeVec
+	sunBetweenRiseSet    prometheus.Gauge
+	sunIncidence         prometheus.Gauge
+	sunApparentSolarTime prometheus.Gauge
+	sunDaysToEquinox     prometheus.Gauge
+	sunDailyInsolation   prometheus.Gauge
+	sunMaxAltitude       prometheus.Gauge
+	sunJulianDay         prometheus.Gauge
+	sunSiderealTime      prometheus.Gauge
+	solarIrradiance      prometheus.Gauge
+)
+
+// MetricsOptions controls how metrics are constructed and registered.
+type MetricsOptions struct {
+	// Namespace is the namespace for observation metrics.
+	Namespace string
+	// SunNamespace is the namespace for sun position metrics.
+	SunNamespace string
+	// SolarNamespace is the namespace for the solar irradiance estimate.
+	SolarNamespace string
+	// ObservationTimestamps exports the observation metrics with the time
+	// of the observation rather than the scrape time.
+	ObservationTimestamps bool
+	// NoSun skips registering the sun and solar metrics entirely.
+	NoSun bool
+	// DebugMetrics registers the intermediate values of the sun position
+	// calculation, for checking it against external ephemerides.
+	DebugMetrics bool
+	// Zone registers the forecast zone aggregate metrics.
+	Zone bool
+	// Forecast registers the daily forecast high and low metrics.
+	Forecast bool
+	// History registers the metrics computed from the recent observation
+	// history.
+	History bool
+	// Units is the unit system observations are exported in, which
+	// determines the unit suffix of the metric names.
+	Units string
+	// LegacyNames drops the unit suffix from metric names, for dashboards
+	// built before the suffixes were added.
+	LegacyNames bool
+	// Smooth registers exponentially smoothed copies of the noisier
+	// observation metrics.
+	Smooth bool
+	// PerStation records the readings of every retrieved station in a
+	// registry of its own, for /metrics/<station>.
+	PerStation bool
+	// TemperatureBuckets are the bucket upper bounds of the temperature
+	// distribution histogram, in the exported units. If empty they span
+	// realistic surface temperatures.
+	TemperatureBuckets []float64
+}
+
+// registerMetrics constructs every metric and registers it with the default
+// prometheus registry. The options come from flags, so this has to run after
+// flag.Parse. Metrics are constructed even when they aren't registered so
+// that setting them is always safe.
+func registerMetrics(opts MetricsOptions) {
+	namespace, sunNamespace := opts.Namespace, opts.SunNamespace
+
+	tempUnit, speedUnit, pressureUnit, distanceUnit, heightUnit := "celsius", "kmh", "pascals", "meters", "meters"
+	if opts.Units == unitsImperial {
+		tempUnit, speedUnit, pressureUnit, distanceUnit, heightUnit = "fahrenheit", "mph", "inhg", "miles", "feet"
+	}
+	// name appends the unit suffix to a metric name, following the
+	// prometheus convention of carrying units in the name
+	name := func(base, unit string) string {
+		if opts.LegacyNames {
+			return base
+		}
+		return base + "_" + unit
+	}
+
+	humidity = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("humidity", "percent"),
+		Help:      "humidity gauge percentage",
+	})
+	stationGaugeOpts.humidity = prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("humidity", "percent"),
+		Help:      "humidity gauge percentage reported by this station",
+	}
+	stationGaugeOpts.temperature = prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("temperature", tempUnit),
+		Help:      "temperature reported by this station in celsius (fahrenheit with -units imperial)",
+	}
+	stationGaugeOpts.dewpoint = prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("dewpoint", tempUnit),
+		Help:      "dewpoint reported by this station in celsius (fahrenheit with -units imperial)",
+	}
+	stationGaugeOpts.pressure = prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("barometric_pressure", pressureUnit),
+		Help:      "barometric pressure reported by this station in pascals (inches of mercury with -units imperial)",
+	}
+	stationGaugeOpts.windspeed = prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("wind_speed", speedUnit),
+		Help:      "wind speed reported by this station in kilometers per hour (miles per hour with -units imperial)",
+	}
+	if opts.PerStation {
+		stationRegistries = map[string]*stationMetrics{}
+	}
+	temperature = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("temperature", tempUnit),
+		Help:      "temperature in celsius (fahrenheit with -units imperial)",
+	})
+	temperatureMax24h = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("temperature_max_24h", tempUnit),
+		Help:      "maximum temperature over the last 24 hours in celsius (fahrenheit with -units imperial)",
+	})
+	temperatureMin24h = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("temperature_min_24h", tempUnit),
+		Help:      "minimum temperature over the last 24 hours in celsius (fahrenheit with -units imperial)",
+	})
+	dewpoint = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("dewpoint", tempUnit),
+		Help:      "dewpoint in celsius (fahrenheit with -units imperial)",
+	})
+	winddirection = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("wind_direction", "degrees"),
+			Help:      "wind direction in degrees",
+		},
+		[]string{"Direction"},
+	)
+	windspeed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("wind_speed", speedUnit),
+		Help:      "wind speed in kilometers per hour (miles per hour with -units imperial)",
+	})
+	windCalm = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "wind_calm",
+		Help:      "1 if the wind is measured as calm, 0 if it is not",
+	})
+	windU = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("wind_u_component", speedUnit),
+		Help:      "east-west component of the wind, speed*sin(direction), in kilometers per hour (miles per hour with -units imperial)",
+	})
+	windV = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("wind_v_component", speedUnit),
+		Help:      "north-south component of the wind, speed*cos(direction), in kilometers per hour (miles per hour with -units imperial)",
+	})
+	fieldsPresent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "observation_fields_present",
+		Help:      "number of the expected readings present in the primary station's latest observation",
+	})
+	fieldsExpected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "observation_fields_expected",
+		Help:      "number of readings counted by observation_fields_present",
+	})
+	fieldsExpected.Set(expectedFields)
+	barometricpressure = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("barometric_pressure", pressureUnit),
+		Help:      "barometric pressure in pascals (inches of mercury with -units imperial)",
+	})
+	sealevelpressure = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("sealevel_pressure", pressureUnit),
+			Help:      "sealevel pressure in pascals (inches of mercury with -units imperial), reported by the station or computed from station pressure",
+		},
+		[]string{"source"},
+	)
+	pressureChange = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("barometric_pressure_change", pressureUnit),
+		Help:      "change in barometric pressure over the last -historyhours in pascals (inches of mercury with -units imperial), negative when falling",
+	})
+	pressure = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pressure",
+			Help:      "barometric pressure in every unit at once, pa, hpa, mb and inhg, regardless of -units",
+		},
+		[]string{"unit"},
+	)
+	pressureSealevel = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pressure_sealevel",
+			Help:      "sealevel pressure in every unit at once, pa, hpa, mb and inhg, regardless of -units, reported by the station or computed from station pressure",
+		},
+		[]string{"unit", "source"},
+	)
+	visibility = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("visibility", distanceUnit),
+		Help:      "visibility in meters (statute miles with -units imperial)",
+	})
+	visibilityUnlim = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "visibility_unlimited",
+		Help:      "1 if visibility is at or above the 10 statute miles most stations report at most, 0 if it is an exact measurement",
+	})
+	cloudcover = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("cloud_cover", heightUnit),
+			Help:      "cloud cover amount and base height in meters (feet with -units imperial)",
+		},
+		[]string{"amount"},
+	)
+	precipitation1h = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "precipitation_last_hour_mm",
+		Help:      "precipitation over the last hour in millimeters",
+	})
+	precipitation3h = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "precipitation_last_3_hours_mm",
+		Help:      "precipitation over the last 3 hours in millimeters",
+	})
+	precipitation6h = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "precipitation_last_6_hours_mm",
+		Help:      "precipitation over the last 6 hours in millimeters",
+	})
+	cloudlayercount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cloud_layer_count",
+		Help:      "number of cloud layers reported (0 = clear sky)",
+	})
+	apparentTemp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("apparent_temperature", tempUnit),
+		Help:      "feels like temperature, the wind chill when cold and windy, the heat index when hot and humid, otherwise the air temperature, in celsius (fahrenheit with -units imperial)",
+	})
+	weatherIcon = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "weather_icon",
+			Help:      "1 for the icon, e.g. clear-day or rain, describing the current conditions",
+		},
+		[]string{"icon"},
+	)
+	conditionCode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "condition_code",
+			Help:      "1 for the NWS condition code, e.g. ovc or tsra, and daypart parsed from the observation's icon URL",
+		},
+		[]string{"code", "daypart", "icon_url"},
+	)
+	temperatureBuckets := opts.TemperatureBuckets
+	if len(temperatureBuckets) == 0 {
+		temperatureBuckets = prometheus.LinearBuckets(-30, 5, 15)
+		if opts.Units == unitsImperial {
+			temperatureBuckets = prometheus.LinearBuckets(-20, 10, 13)
+		}
+	}
+	temperatureDist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      name("temperature_distribution", tempUnit),
+		Help:      "distribution of observed temperatures over the life of the process in celsius (fahrenheit with -units imperial), counting each observation once",
+		Buckets:   temperatureBuckets,
+	})
+	heatIndex = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("heat_index", tempUnit),
+			Help:      "heat index in celsius (fahrenheit with -units imperial), reported by the station or computed from temperature and humidity when it applies",
+		},
+		[]string{"source"},
+	)
+	windChill = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("wind_chill", tempUnit),
+			Help:      "wind chill in celsius (fahrenheit with -units imperial), reported by the station or computed from temperature and wind speed when it applies",
+		},
+		[]string{"source"},
+	)
+	thswIndex = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("thsw_index", tempUnit),
+		Help:      "approximate temperature-humidity-sun-wind apparent temperature in celsius (fahrenheit with -units imperial)",
+	})
+	cloudBase = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("estimated_cloud_base", heightUnit),
+		Help:      "estimated cumulus cloud base above the station in meters (feet with -units imperial), from the temperature-dewpoint spread",
+	})
+	humiditySmoothed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("humidity_smoothed", "percent"),
+		Help:      "exponential moving average of humidity percentage",
+	})
+	temperatureSmoothed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("temperature_smoothed", tempUnit),
+		Help:      "exponential moving average of temperature in celsius (fahrenheit with -units imperial)",
+	})
+	dewpointSmoothed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("dewpoint_smoothed", tempUnit),
+		Help:      "exponential moving average of dewpoint in celsius (fahrenheit with -units imperial)",
+	})
+	windspeedSmoothed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("wind_speed_smoothed", speedUnit),
+		Help:      "exponential moving average of wind speed in kilometers per hour (miles per hour with -units imperial)",
+	})
+	barometricpressureSmoothed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name("barometric_pressure_smoothed", pressureUnit),
+		Help:      "exponential moving average of barometric pressure in pascals (inches of mercury with -units imperial)",
+	})
+	zoneTemperature = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("zone_temperature", tempUnit),
+			Help:      "mean temperature across the stations in the forecast zone in celsius (fahrenheit with -units imperial)",
+		},
+		[]string{"zone"},
+	)
+	zoneHumidity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("zone_humidity", "percent"),
+			Help:      "mean humidity percentage across the stations in the forecast zone",
+		},
+		[]string{"zone"},
+	)
+	zoneStationsReporting = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "zone_stations_reporting",
+			Help:      "number of stations in the forecast zone contributing a temperature",
+		},
+		[]string{"zone"},
+	)
+	forecastHigh = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("forecast_high_temperature", tempUnit),
+			Help:      "forecast high temperature in celsius (fahrenheit with -units imperial) for each day, 0 for today",
+		},
+		[]string{"day"},
+	)
+	forecastLow = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name("forecast_low_temperature", tempUnit),
+			Help:      "forecast overnight low temperature in celsius (fahrenheit with -units imperial) for each day, 0 for tonight",
+		},
+		[]string{"day"},
+	)
+	observationCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "observation_cache_hits_total",
+		Help:      "number of observation requests answered with 304 Not Modified",
+	})
+	httpResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_responses_total",
+			Help:      "number of observation responses by HTTP status code and station",
+		},
+		[]string{"code", "station"},
+	)
+	scrapeLoopIterations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scrape_loop_iterations_total",
+		Help:      "number of scrape loop iterations, successful or not",
+	})
+	scrapePanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scrape_panics_total",
+		Help:      "number of panics recovered from while scraping",
+	})
+	reportingStation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "reporting_station",
+			Help:      "1 for the station that produced the exported observation, which can differ from the requested one with -uselist or when falling back",
+		},
+		[]string{"station", "reporting_station"},
+	)
+	fallbackFields = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "fallback_field",
+			Help:      "1 for each reading taken from a fallback station instead of the primary station in the last scrape",
+		},
+		[]string{"field", "station"},
+	)
+	duplicateObs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_observations_total",
+		Help:      "number of new observations from the primary station whose readings were identical to the previous one, a sign of a frozen sensor",
+	})
+	observationStale = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "observation_stale_seconds",
+		Help:      "seconds since the observation timestamp last changed, which keeps growing while a reachable station stops reporting",
+	}, ObservationStaleSeconds)
+	httpPhaseSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_phase_seconds",
+			Help:      "time spent in each phase of observation requests: dns, connect, tls and ttfb (time to first byte)",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"phase"},
+	)
+	sunAltitude = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "altitude",
+		Help:      "sun altitude in degrees above horizon (negative = below horizon)",
+	})
+	sunAzimuth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "azimuth",
+		Help:      "sun azimuth in degrees from North (0=N, 90=E, 180=S, 270=W)",
+	})
+	sunAzimuthCardinal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: sunNamespace,
+			Name:      "azimuth_cardinal",
+			Help:      "1 for the cardinal direction the sun currently bears",
+		},
+		[]string{"direction"},
+	)
+	sunHourAngle = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "hour_angle_degrees",
+		Help:      "sun hour angle in degrees (negative before solar noon, positive after)",
+	})
+	sunIsDaylight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "is_daylight",
+		Help:      "1 if sun is above horizon, 0 if below",
+	})
+	sunSunrise = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "sunrise_time",
+		Help:      "today's sunrise time as Unix timestamp",
+	})
+	sunSunset = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "sunset_time",
+		Help:      "today's sunset time as Unix timestamp",
+	})
+	// Computed on every scrape from the cached event times rather than set
+	// by the scrape loop, so they count down smoothly between scrapes
+	sunSecondsToSunrise = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "seconds_to_sunrise",
+		Help:      "seconds until the next sunrise",
+	}, func() float64 {
+		return secondsUntilSunEvent(func(p *SunPosition) time.Time { return p.NextSunrise })
+	})
+	sunSecondsToSunset = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "seconds_to_sunset",
+		Help:      "seconds until the next sunset",
+	}, func() float64 {
+		return secondsUntilSunEvent(func(p *SunPosition) time.Time { return p.NextSunset })
+	})
+	sunDistanceAU = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "distance_au",
+		Help:      "Earth-Sun distance in astronomical units",
+	})
+	sunAngularDiam = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "angular_diameter_arcmin",
+		Help:      "apparent diameter of the solar disk in arcminutes",
+	})
+	sunAltitudeRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "altitude_rate_deg_per_min",
+		Help:      "rate the sun's altitude is changing in degrees per minute, positive while rising",
+	})
+	sunApparentSolarTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "apparent_solar_time_seconds",
+		Help:      "seconds since local apparent solar midnight, the time a sundial shows, which differs from clock time by the equation of time and the longitude's offset within the timezone",
+	})
+	sunIncidence = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "angle_of_incidence_degrees",
+		Help:      "angle between the sun and the normal of the panel set by -paneltilt and -panelazimuth, over 90 when the panel is in its own shadow",
+	})
+	sunBetweenRiseSet = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "between_rise_set",
+		Help:      "1 if the current time is between today's computed sunrise and sunset, unlike is_daylight which compares the sun's altitude",
+	})
+	sunSeason = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: sunNamespace,
+			Name:      "season",
+			Help:      "1 for the current astronomical season at the sun coordinates, spring, summer, autumn or winter",
+		},
+		[]string{"season"},
+	)
+	sunDaysToSolstice = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "days_to_solstice",
+		Help:      "days until the next solstice",
+	})
+	sunDaysToEquinox = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "days_to_equinox",
+		Help:      "days until the next equinox",
+	})
+	sunDailyInsolation = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "daily_insolation_kwh_m2",
+		Help:      "today's clear-sky solar energy on a horizontal surface in kilowatt hours per square meter",
+	})
+	sunMaxAltitude = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "max_altitude_today_degrees",
+		Help:      "sun altitude at solar noon today in degrees, the highest it gets",
+	})
+	sunJulianDay = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "julian_day",
+		Help:      "Julian day the sun position was last calculated for",
+	})
+	sunSiderealTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: sunNamespace,
+		Name:      "local_sidereal_time_degrees",
+		Help:      "local sidereal time in degrees the sun position was last calculated for",
+	})
+	solarIrradiance = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: opts.SolarNamespace,
+		Name:      "poa_irradiance_wm2",
+		Help:      "estimated clear-sky irradiance on a horizontal surface in watts per square meter, attenuated by cloud cover",
+	})
+
+	observationMetrics := []prometheus.Collector{
+		humidity,
+		temperature,
+		temperatureMax24h,
+		temperatureMin24h,
+		dewpoint,
+		winddirection,
+		windspeed,
+		windCalm,
+		windU,
+		windV,
+		barometricpressure,
+		sealevelpressure,
+		pressure,
+		pressureSealevel,
+		visibility,
+		visibilityUnlim,
+		cloudcover,
+		precipitation1h,
+		precipitation3h,
+		precipitation6h,
+		cloudlayercount,
+		thswIndex,
+		cloudBase,
+		apparentTemp,
+		heatIndex,
+		windChill,
+		weatherIcon,
+		conditionCode,
+		fieldsPresent,
+		fieldsExpected,
+	}
+	if opts.Smooth {
+		observationMetrics = append(observationMetrics,
+			humiditySmoothed,
+			temperatureSmoothed,
+			dewpointSmoothed,
+			windspeedSmoothed,
+			barometricpressureSmoothed,
+		)
+	}
+	var observations prometheus.Collector = &missingCollector{collectors: observationMetrics}
+	if opts.ObservationTimestamps {
+		observations = &timestampCollector{collectors: []prometheus.Collector{observations}}
+	}
+	prometheus.MustRegister(observations)
+	if opts.Zone {
+		prometheus.MustRegister(zoneTemperature, zoneHumidity, zoneStationsReporting)
+	}
+	if opts.Forecast {
+		prometheus.MustRegister(forecastHigh, forecastLow)
+	}
+	if opts.History {
+		prometheus.MustRegister(&missingCollector{[]prometheus.Collector{pressureChange}})
+	}
+	prometheus.MustRegister(observationCacheHits)
+	prometheus.MustRegister(httpResponses)
+	prometheus.MustRegister(scrapeLoopIterations)
+	prometheus.MustRegister(httpPhaseSeconds)
+	prometheus.MustRegister(observationStale)
+	prometheus.MustRegister(scrapePanics)
+	prometheus.MustRegister(duplicateObs)
+	prometheus.MustRegister(fallbackFields)
+	prometheus.MustRegister(reportingStation)
+	prometheus.MustRegister(temperatureDist)
+	if !opts.NoSun {
+		prometheus.MustRegister(sunAltitude)
+		prometheus.MustRegister(sunAzimuth)
+		prometheus.MustRegister(sunAzimuthCardinal)
+		prometheus.MustRegister(sunHourAngle)
+		prometheus.MustRegister(sunIsDaylight)
+		prometheus.MustRegister(sunSunrise)
+		prometheus.MustRegister(sunSunset)
+		prometheus.MustRegister(sunSecondsToSunrise)
+		prometheus.MustRegister(sunSecondsToSunset)
+		prometheus.MustRegister(sunDistanceAU)
+		prometheus.MustRegister(sunAngularDiam)
+		prometheus.MustRegister(sunAltitudeRate)
+		prometheus.MustRegister(sunSeason)
+		prometheus.MustRegister(sunBetweenRiseSet)
+		prometheus.MustRegister(sunIncidence)
+		prometheus.MustRegister(sunApparentSolarTime)
+		prometheus.MustRegister(sunDaysToSolstice)
+		prometheus.MustRegister(sunDaysToEquinox)
+		prometheus.MustRegister(sunDailyInsolation)
+		prometheus.MustRegister(sunMaxAltitude)
+		if opts.DebugMetrics {
+			prometheus.MustRegister(sunJulianDay)
+			prometheus.MustRegister(sunSiderealTime)
+		}
+		prometheus.MustRegister(solarIrradiance)
+	}
+}
+
+// secondsUntilSunEvent returns the seconds from now until the sun event
+// selected by event in the last computed sun position. Now is shifted by
+// -timeoffset like the sun position itself. It goes negative if the event
+// passes before the next scrape, and is NaN if there is no event.
+func secondsUntilSunEvent(event func(*SunPosition) time.Time) float64 {
+	c, ok := LastConditions()
+	if !ok || c.Sun == nil || event(c.Sun).IsZero() {
+		return math.NaN()
+	}
+	return event(c.Sun).Sub(offsetNow()).Seconds()
+}
+
+// allowlistGatherer only passes through the metric families named in allow,
+// so -metrics can trim what is exported without every gauge update needing
+// to know about it. The filtered metrics are still registered and updated,
+// they are only dropped at gather time.
+type allowlistGatherer struct {
+	gatherer prometheus.Gatherer
+	allow    map[string]bool
+}
+
+// Gather implements prometheus.Gatherer.
+func (g allowlistGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	filtered := families[:0]
+	for _, mf := range families {
+		if g.allow[mf.GetName()] {
+			filtered = append(filtered, mf)
+		}
+	}
+	return filtered, err
+}
+
+// allowMetrics returns gatherer restricted to the metrics with the full names
+// in allow, e.g. nws_temperature_celsius, or gatherer itself if allow is
+// empty. Every handler serving metrics goes through it, so -metrics applies
+// to all of them.
+func allowMetrics(gatherer prometheus.Gatherer, allow []string) prometheus.Gatherer {
+	if len(allow) == 0 {
+		return gatherer
+	}
+	g := allowlistGatherer{gatherer: gatherer, allow: map[string]bool{}}
+	for _, name := range allow {
+		g.allow[strings.TrimSpace(name)] = true
+	}
+	return g
+}
+
+// metricsHandler returns the /metrics handler serving gatherer, restricted
+// to allow by allowMetrics.
+func metricsHandler(gatherer prometheus.Gatherer, allow []string) http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(allowMetrics(gatherer, allow), promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// get serves a GET of path from h and returns the response body.
+func get(t *testing.T, h http.Handler, path string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsAllowlist(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	kept := prometheus.NewGauge(prometheus.GaugeOpts{Name: "kept"})
+	dropped := prometheus.NewGauge(prometheus.GaugeOpts{Name: "dropped"})
+	reg.MustRegister(kept, dropped)
+
+	body := get(t, metricsHandler(reg, []string{"kept", " other"}), "/metrics")
+	if !strings.Contains(body, "kept 0") || strings.Contains(body, "dropped") {
+		t.Errorf("/metrics with an allowlist served:\n%s", body)
+	}
+	body = get(t, metricsHandler(reg, nil), "/metrics")
+	if !strings.Contains(body, "kept 0") || !strings.Contains(body, "dropped 0") {
+		t.Errorf("/metrics without an allowlist served:\n%s", body)
+	}
+}
+
+func TestStationMetricsAllowlist(t *testing.T) {
+	stationRegistriesMu.Lock()
+	saved := stationRegistries
+	stationRegistries = map[string]*stationMetrics{}
+	stationRegistriesMu.Unlock()
+	defer func() {
+		stationRegistriesMu.Lock()
+		stationRegistries = saved
+		stationRegistriesMu.Unlock()
+	}()
+
+	var resp ObservationResponse
+	resp.Properties.Temperature.Value = 21.5
+	resp.Properties.Dewpoint.Value = 15
+	recordStation("PHOG", resp)
+
+	temperature := prometheus.BuildFQName(stationGaugeOpts.temperature.Namespace, "", stationGaugeOpts.temperature.Name)
+	dewpoint := prometheus.BuildFQName(stationGaugeOpts.dewpoint.Namespace, "", stationGaugeOpts.dewpoint.Name)
+
+	body := get(t, stationMetricsHandler([]string{temperature}), "/metrics/PHOG")
+	if !strings.Contains(body, temperature+" 21.5") || strings.Contains(body, dewpoint) {
+		t.Errorf("/metrics/PHOG with an allowlist served:\n%s", body)
+	}
+	body = get(t, stationMetricsHandler(nil), "/metrics/phog")
+	if !strings.Contains(body, temperature+" 21.5") || !strings.Contains(body, dewpoint+" 15") {
+		t.Errorf("/metrics/phog without an allowlist served:\n%s", body)
+	}
+	if body := get(t, stationMetricsHandler(nil), "/metrics/KPHL"); !strings.Contains(body, "no observation") {
+		t.Errorf("/metrics/KPHL served:\n%s", body)
+	}
+}
+
+func TestSecondsUntilSunEventOffset(t *testing.T) {
+	defer func(offset time.Duration) { timeoffset = offset }(timeoffset)
+	lastConditions.Lock()
+	saved := lastConditions.c
+	lastConditions.Unlock()
+	defer func() {
+		lastConditions.Lock()
+		lastConditions.c = saved
+		lastConditions.Unlock()
+	}()
+
+	timeoffset = 48 * time.Hour
+	sun := SunPosition{NextSunrise: offsetNow().Add(time.Hour)}
+	SetLastConditions(Conditions{Sun: &sun})
+	if got := secondsUntilSunEvent(func(s *SunPosition) time.Time { return s.NextSunrise }); math.Abs(got-3600) > 5 {
+		t.Errorf("seconds until sunrise = %v, want about 3600", got)
+	}
+	if got := secondsUntilSunEvent(func(s *SunPosition) time.Time { return s.NextSunset }); !math.IsNaN(got) {
+		t.Errorf("seconds until a missing sunset = %v, want NaN", got)
+	}
+}
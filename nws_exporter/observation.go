@@ -2,13 +2,84 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"math"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// ObservationList is the json structure returned by the national weather
+// service observation list api, newest observation first.
+type ObservationList struct {
+	Features   []ObservationResponse `json:"features"`
+	Pagination struct {
+		// Next is the url of the following, older, page of observations.
+		Next string `json:"next"`
+	} `json:"pagination"`
+}
+
+// observationListLimit is how many recent observations to request when
+// using the observation list instead of the latest endpoint.
+const observationListLimit = 10
+
+// ObservationOptions controls how observations are retrieved.
+type ObservationOptions struct {
+	// UseList fetches the station's recent observation list and picks the
+	// newest one with data, working around the latest endpoint lagging.
+	UseList bool
+	// MaxRetries is how many more times to try a station within a single
+	// scrape after a failed request.
+	MaxRetries int
+	// Mirrors are additional api hosts serving the same data, tried in
+	// order when a connection to the primary address fails.
+	Mirrors []string
+	// MaxResponseBytes fails a request whose body is larger than this,
+	// rather than reading a pathological response into memory. Zero means
+	// no limit.
+	MaxResponseBytes int64
+	// Timeout bounds each observation request, in addition to the client's
+	// overall timeout. Zero means only the client timeout applies.
+	Timeout time.Duration
+	// DumpRaw logs the raw response body, up to dumpRawLimit bytes, before
+	// it is parsed.
+	DumpRaw bool
+	// FieldMap renames observation properties before they are parsed, see
+	// LoadFieldMap.
+	FieldMap map[string]string
+	// Source is where observations come from, sourceGeoJSON for the NWS
+	// api or sourceMETAR for raw METARs from the aviation weather center.
+	Source string
+}
+
+// dumpRawLimit is how much of a response body DumpRaw logs.
+const dumpRawLimit = 4096
+
+// cachedObservation is the last successful response for a station along with
+// the validators needed to make a conditional request for it.
+type cachedObservation struct {
+	etag         string
+	lastModified string
+	response     ObservationResponse
+}
+
+// observationCache holds the last observation per request URL so unchanged
+// observations can be revalidated with a 304 instead of refetched.
+var (
+	observationCacheMu sync.Mutex
+	observationCache   = map[string]cachedObservation{}
+)
+
 // ObservationResponse is the json structure returned by the national weather
 // service observations api.
 type ObservationResponse struct {
@@ -18,121 +89,214 @@ type ObservationResponse struct {
 		Type        string    `json:"type"`
 		Coordinates []float64 `json:"coordinates"`
 	} `json:"geometry"`
-	Properties struct {
-		ID        string `json:"@id"`
-		Type      string `json:"@type"`
-		Elevation struct {
-			Value    int    `json:"value"`
-			UnitCode string `json:"unitCode"`
-		} `json:"elevation"`
-		Station         string        `json:"station"`
-		Timestamp       time.Time     `json:"timestamp"`
-		RawMessage      string        `json:"rawMessage"`
-		TextDescription string        `json:"textDescription"`
-		Icon            string        `json:"icon"`
-		PresentWeather  []interface{} `json:"presentWeather"`
-		Temperature     struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"temperature"`
-		Dewpoint struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"dewpoint"`
-		WindDirection struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"windDirection"`
-		WindSpeed struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"windSpeed"`
-		WindGust struct {
-			Value          interface{} `json:"value"`
-			UnitCode       string      `json:"unitCode"`
-			QualityControl string      `json:"qualityControl"`
-		} `json:"windGust"`
-		BarometricPressure struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"barometricPressure"`
-		SeaLevelPressure struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"seaLevelPressure"`
-		Visibility struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"visibility"`
-		MaxTemperatureLast24Hours struct {
-			Value          interface{} `json:"value"`
-			UnitCode       string      `json:"unitCode"`
-			QualityControl interface{} `json:"qualityControl"`
-		} `json:"maxTemperatureLast24Hours"`
-		MinTemperatureLast24Hours struct {
-			Value          interface{} `json:"value"`
-			UnitCode       string      `json:"unitCode"`
-			QualityControl interface{} `json:"qualityControl"`
-		} `json:"minTemperatureLast24Hours"`
-		PrecipitationLastHour struct {
-			Value          interface{} `json:"value"`
-			UnitCode       string      `json:"unitCode"`
-			QualityControl string      `json:"qualityControl"`
-		} `json:"precipitationLastHour"`
-		PrecipitationLast3Hours struct {
-			Value          interface{} `json:"value"`
-			UnitCode       string      `json:"unitCode"`
-			QualityControl string      `json:"qualityControl"`
-		} `json:"precipitationLast3Hours"`
-		PrecipitationLast6Hours struct {
-			Value          interface{} `json:"value"`
-			UnitCode       string      `json:"unitCode"`
-			QualityControl string      `json:"qualityControl"`
-		} `json:"precipitationLast6Hours"`
-		RelativeHumidity struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"relativeHumidity"`
-		WindChill struct {
-			Value          interface{} `json:"value"`
-			UnitCode       string      `json:"unitCode"`
-			QualityControl string      `json:"qualityControl"`
-		} `json:"windChill"`
-		HeatIndex struct {
-			Value          float64 `json:"value"`
-			UnitCode       string  `json:"unitCode"`
-			QualityControl string  `json:"qualityControl"`
-		} `json:"heatIndex"`
-		CloudLayers []struct {
-			Base struct {
-				Value    int    `json:"value"`
-				UnitCode string `json:"unitCode"`
-			} `json:"base"`
-			Amount string `json:"amount"`
-		} `json:"cloudLayers"`
-	} `json:"properties"`
+	Properties ObservationProperties `json:"properties"`
+}
+
+// ObservationProperties holds the measurements of a single observation.
+type ObservationProperties struct {
+	ID        string `json:"@id"`
+	Type      string `json:"@type"`
+	Elevation struct {
+		Value    int    `json:"value"`
+		UnitCode string `json:"unitCode"`
+	} `json:"elevation"`
+	Station         string        `json:"station"`
+	Timestamp       time.Time     `json:"timestamp"`
+	RawMessage      string        `json:"rawMessage"`
+	TextDescription string        `json:"textDescription"`
+	Icon            string        `json:"icon"`
+	PresentWeather  []interface{} `json:"presentWeather"`
+	Temperature     struct {
+		Value          float64 `json:"value"`
+		UnitCode       string  `json:"unitCode"`
+		QualityControl string  `json:"qualityControl"`
+	} `json:"temperature"`
+	Dewpoint struct {
+		Value          float64 `json:"value"`
+		UnitCode       string  `json:"unitCode"`
+		QualityControl string  `json:"qualityControl"`
+	} `json:"dewpoint"`
+	WindDirection struct {
+		Value          *float64 `json:"value"`
+		UnitCode       string   `json:"unitCode"`
+		QualityControl string   `json:"qualityControl"`
+	} `json:"windDirection"`
+	WindSpeed struct {
+		Value          *float64 `json:"value"`
+		UnitCode       string   `json:"unitCode"`
+		QualityControl string   `json:"qualityControl"`
+	} `json:"windSpeed"`
+	WindGust struct {
+		Value          interface{} `json:"value"`
+		UnitCode       string      `json:"unitCode"`
+		QualityControl string      `json:"qualityControl"`
+	} `json:"windGust"`
+	BarometricPressure struct {
+		Value          float64 `json:"value"`
+		UnitCode       string  `json:"unitCode"`
+		QualityControl string  `json:"qualityControl"`
+	} `json:"barometricPressure"`
+	SeaLevelPressure struct {
+		Value          float64 `json:"value"`
+		UnitCode       string  `json:"unitCode"`
+		QualityControl string  `json:"qualityControl"`
+	} `json:"seaLevelPressure"`
+	Visibility struct {
+		Value          float64 `json:"value"`
+		UnitCode       string  `json:"unitCode"`
+		QualityControl string  `json:"qualityControl"`
+	} `json:"visibility"`
+	MaxTemperatureLast24Hours struct {
+		Value          float64     `json:"value"`
+		UnitCode       string      `json:"unitCode"`
+		QualityControl interface{} `json:"qualityControl"`
+	} `json:"maxTemperatureLast24Hours"`
+	MinTemperatureLast24Hours struct {
+		Value          float64     `json:"value"`
+		UnitCode       string      `json:"unitCode"`
+		QualityControl interface{} `json:"qualityControl"`
+	} `json:"minTemperatureLast24Hours"`
+	PrecipitationLastHour struct {
+		Value          *float64 `json:"value"`
+		UnitCode       string   `json:"unitCode"`
+		QualityControl string   `json:"qualityControl"`
+	} `json:"precipitationLastHour"`
+	PrecipitationLast3Hours struct {
+		Value          *float64 `json:"value"`
+		UnitCode       string   `json:"unitCode"`
+		QualityControl string   `json:"qualityControl"`
+	} `json:"precipitationLast3Hours"`
+	PrecipitationLast6Hours struct {
+		Value          *float64 `json:"value"`
+		UnitCode       string   `json:"unitCode"`
+		QualityControl string   `json:"qualityControl"`
+	} `json:"precipitationLast6Hours"`
+	RelativeHumidity struct {
+		Value          float64 `json:"value"`
+		UnitCode       string  `json:"unitCode"`
+		QualityControl string  `json:"qualityControl"`
+	} `json:"relativeHumidity"`
+	WindChill struct {
+		Value          *float64 `json:"value"`
+		UnitCode       string   `json:"unitCode"`
+		QualityControl string   `json:"qualityControl"`
+	} `json:"windChill"`
+	HeatIndex struct {
+		Value          *float64 `json:"value"`
+		UnitCode       string   `json:"unitCode"`
+		QualityControl string   `json:"qualityControl"`
+	} `json:"heatIndex"`
+	CloudLayers []CloudLayer `json:"cloudLayers"`
+}
+
+// valueOf returns the value of a nullable reading, or 0 if it was null.
+func valueOf(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// calmWindSpeed is the wind speed in kilometers per hour below which wind is
+// considered calm, matching the METAR convention of reporting under 1 knot
+// as 00000KT.
+const calmWindSpeed = 1.852
+
+// IsCalm reports whether the observation describes calm wind: a wind speed
+// was measured and is effectively zero, and no direction was given. This is
+// distinct from an observation with no wind data at all.
+func (o ObservationResponse) IsCalm() bool {
+	speed := o.Properties.WindSpeed.Value
+	return speed != nil && *speed < calmWindSpeed && o.Properties.WindDirection.Value == nil
+}
+
+// unlimitedVisibility is the visibility in meters at or above which it is
+// reported as unlimited. Most stations report at most 10 statute miles,
+// 16093 meters, which can come through rounded down slightly.
+const unlimitedVisibility = 16000
+
+// StationIdentifier returns the station identifier, e.g. PHOG, from the
+// station url in an observation's properties, or "unknown" if it has none.
+func StationIdentifier(stationURL string) string {
+	u, err := url.Parse(stationURL)
+	if err != nil || u.Path == "" {
+		return "unknown"
+	}
+	return path.Base(u.Path)
+}
+
+// SameReadings reports whether two observations carry exactly the same
+// readings, ignoring the id, timestamp and raw message that change with every
+// observation even when a frozen sensor keeps reporting the same values.
+func SameReadings(a, b ObservationProperties) bool {
+	a.ID, a.Timestamp, a.RawMessage = "", time.Time{}, ""
+	b.ID, b.Timestamp, b.RawMessage = "", time.Time{}, ""
+	return reflect.DeepEqual(a, b)
+}
+
+// expectedFields is the number of readings counted by FieldsPresent.
+const expectedFields = 7
+
+// FieldsPresent counts how many of the temperature, humidity, dewpoint,
+// wind speed, barometric pressure, visibility and cloud layer readings are
+// present in the observation.
+func (o ObservationResponse) FieldsPresent() int {
+	p := o.Properties
+	present := 0
+	for _, ok := range []bool{
+		p.Temperature.Value != 0,
+		p.RelativeHumidity.Value != 0,
+		p.Dewpoint.Value != 0,
+		p.WindSpeed.Value != nil,
+		p.BarometricPressure.Value != 0,
+		p.Visibility.Value != 0,
+		len(p.CloudLayers) > 0,
+	} {
+		if ok {
+			present++
+		}
+	}
+	return present
+}
+
+// CloudLayer is a single layer of cloud cover within an observation. Amount
+// is the METAR sky cover code, e.g. FEW, SCT, BKN or OVC.
+type CloudLayer struct {
+	Base struct {
+		Value    int    `json:"value"`
+		UnitCode string `json:"unitCode"`
+	} `json:"base"`
+	Amount string `json:"amount"`
+}
+
+// stationPattern matches NWS station identifiers. Most are four letter ICAO
+// codes like KPHL, but some networks use up to five letters and digits.
+var stationPattern = regexp.MustCompile(`^[A-Z0-9]{3,5}$`)
+
+// ValidateStation returns an error if id is obviously not a NWS station
+// identifier, so typos are caught at startup instead of as an opaque HTTP
+// error on the first scrape.
+func ValidateStation(id string) error {
+	if !stationPattern.MatchString(id) {
+		return fmt.Errorf("invalid station %q, expected an uppercase identifier such as KPHL", id)
+	}
+	return nil
 }
 
 // RetrieveCurrentObservation performs a GET request agains a given national
 // weather service endpoint and returns the ObservationResponse object if the
-// request was successful, and return an error otherwise.
-func RetrieveCurrentObservation(station string, address string, timeout int) (ObservationResponse, error) {
+// request was successful, and return an error otherwise. Requests are made
+// conditional on the last response for the station, and a 304 Not Modified
+// reuses the previously parsed observation.
+func RetrieveCurrentObservation(client *http.Client, station string, address string, opts ObservationOptions) (ObservationResponse, error) {
 	requestURL := url.URL{
 		Scheme: "https",
 		Host:   address,
 		Path:   fmt.Sprintf("/stations/%s/observations/latest", station),
 	}
-
-	client := http.Client{
-		Timeout: time.Duration(timeout) * time.Second,
+	if opts.UseList {
+		requestURL.Path = fmt.Sprintf("/stations/%s/observations", station)
+		requestURL.RawQuery = fmt.Sprintf("limit=%d", observationListLimit)
 	}
 
 	response := ObservationResponse{}
@@ -143,6 +307,23 @@ func RetrieveCurrentObservation(station string, address string, timeout int) (Ob
 	}
 
 	req.Header.Add("Accept", "application/geo+json")
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), newPhaseTrace()))
+	req, cancel := withTimeout(req, opts.Timeout)
+	defer cancel()
+
+	cacheKey := requestURL.String()
+	observationCacheMu.Lock()
+	cached, haveCached := observationCache[cacheKey]
+	observationCacheMu.Unlock()
+	if haveCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -150,35 +331,231 @@ func RetrieveCurrentObservation(station string, address string, timeout int) (Ob
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	httpResponses.WithLabelValues(strconv.Itoa(resp.StatusCode), station).Inc()
+
+	if resp.StatusCode == http.StatusNotModified && haveCached {
+		observationCacheHits.Inc()
+		return cached.response, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return ObservationResponse{}, newStatusError(resp)
+	}
+
+	body, err := readBody(resp, opts)
 	if err != nil {
 		return response, err
 	}
 
-	if resp.StatusCode != 200 {
-		return ObservationResponse{}, fmt.Errorf("err: %d, %s", resp.StatusCode, string(body))
+	if len(opts.FieldMap) > 0 {
+		if body, err = remapFields(body, opts.FieldMap, opts.UseList); err != nil {
+			return response, err
+		}
 	}
 
-	err = json.Unmarshal(body, &response)
+	if opts.UseList {
+		response, err = newestObservation(body)
+	} else {
+		err = json.Unmarshal(body, &response)
+	}
 	if err != nil {
 		return response, err
 	}
+	// During maintenance NWS can answer 200 with null or missing
+	// properties, which would otherwise parse as an observation of zeros
+	if response.Properties.Timestamp.IsZero() {
+		return ObservationResponse{}, fmt.Errorf("observation for %s has no properties", station)
+	}
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag != "" || lastModified != "" {
+		observationCacheMu.Lock()
+		observationCache[cacheKey] = cachedObservation{
+			etag:         etag,
+			lastModified: lastModified,
+			response:     response,
+		}
+		observationCacheMu.Unlock()
+	}
 
 	return response, err
 }
 
-// CardinalDirection takes a given degree on a 360 degree axis and returns the
-// cardinal direction of the given degree. This being either North, South, East
-// or West.
-func CardinalDirection(degree float64) string {
-	switch {
-	case degree >= 0 && degree < 45 || degree > 315 && degree <= 360:
-		return "North"
-	case degree >= 45 && degree <= 135:
-		return "East"
-	case degree > 135 && degree < 225:
-		return "South"
+// newestObservation parses an observation list and returns the most recent
+// observation that has a temperature, skipping entries that are still empty.
+func newestObservation(body []byte) (ObservationResponse, error) {
+	var list ObservationList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return ObservationResponse{}, err
+	}
+
+	var newest ObservationResponse
+	found := false
+	for _, obs := range list.Features {
+		if obs.Properties.Temperature.Value == 0 {
+			continue
+		}
+		if !found || obs.Properties.Timestamp.After(newest.Properties.Timestamp) {
+			newest = obs
+			found = true
+		}
+	}
+	if !found {
+		return ObservationResponse{}, fmt.Errorf("no usable observations in list of %d", len(list.Features))
+	}
+	return newest, nil
+}
+
+// readBody reads the decoded body of resp, failing if it is larger than
+// opts.MaxResponseBytes, and logs it if opts.DumpRaw is set.
+func readBody(resp *http.Response, opts ObservationOptions) ([]byte, error) {
+	// The limit applies to the decompressed body, so a small compressed
+	// response can't expand without bound
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer decoded.Close()
+	var reader io.Reader = decoded
+	if opts.MaxResponseBytes > 0 {
+		// Read one byte past the limit to tell a body that fits exactly
+		// from one that was truncated
+		reader = io.LimitReader(reader, opts.MaxResponseBytes+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxResponseBytes > 0 && int64(len(body)) > opts.MaxResponseBytes {
+		return nil, fmt.Errorf("response from %s exceeds %d bytes", resp.Request.URL.Host, opts.MaxResponseBytes)
+	}
+
+	if opts.DumpRaw {
+		raw := body
+		if len(raw) > dumpRawLimit {
+			raw = raw[:dumpRawLimit]
+		}
+		log.Printf("Raw response from %s (%d, %d bytes): %s", resp.Request.URL.String(), resp.StatusCode, len(body), raw)
+	}
+	return body, nil
+}
+
+// retrieveFromMirrors calls RetrieveCurrentObservation against address, and
+// on a connection failure against each of opts.Mirrors in turn. HTTP error
+// responses are returned as-is, since a mirror would serve the same data.
+func retrieveFromMirrors(client *http.Client, station string, address string, opts ObservationOptions) (ObservationResponse, error) {
+	if opts.Source == sourceMETAR {
+		return RetrieveMETARObservation(client, station, metarAddress, opts)
+	}
+	response, err := RetrieveCurrentObservation(client, station, address, opts)
+	for _, mirror := range opts.Mirrors {
+		var urlErr *url.Error
+		if err == nil || !errors.As(err, &urlErr) {
+			break
+		}
+		log.Printf("Problem connecting to %s for station %s, trying mirror %s: %v", address, station, mirror, err)
+		address = mirror
+		response, err = RetrieveCurrentObservation(client, station, address, opts)
+	}
+	return response, err
+}
+
+// retryDelay is how long to wait between attempts at the same station.
+const retryDelay = 2 * time.Second
+
+// retrieveWithRetries calls RetrieveCurrentObservation, retrying failed
+// requests up to opts.MaxRetries times and logging each attempt.
+func retrieveWithRetries(client *http.Client, station string, address string, opts ObservationOptions) (ObservationResponse, error) {
+	attempts := opts.MaxRetries + 1
+	var response ObservationResponse
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		response, err = retrieveFromMirrors(client, station, address, opts)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Retry succeeded: attempt=%d/%d station=%s", attempt, attempts, station)
+			}
+			return response, nil
+		}
+		if !Retriable(err) {
+			log.Printf("Not retrying: station=%s error=%v", station, err)
+			return response, err
+		}
+		if attempt < attempts {
+			log.Printf("Retrying: attempt=%d/%d station=%s error=%v", attempt, attempts, station, err)
+			time.Sleep(retryDelay)
+		}
+	}
+	if attempts > 1 {
+		log.Printf("Giving up: attempts=%d station=%s error=%v", attempts, station, err)
+	}
+	return response, err
+}
+
+// maxConcurrentFetches caps how many stations are fetched at once.
+const maxConcurrentFetches = 4
+
+// StationResult is the outcome of retrieving a single station's observation.
+type StationResult struct {
+	Response ObservationResponse
+	Err      error
+}
+
+// RetrieveObservations retrieves the current observation for each station
+// concurrently, sharing the given client, and returns the results in the same
+// order as stations. At most maxConcurrentFetches requests are in flight.
+func RetrieveObservations(client *http.Client, stations []string, address string, opts ObservationOptions) []StationResult {
+	results := make([]StationResult, len(stations))
+	sem := make(chan struct{}, maxConcurrentFetches)
+	var wg sync.WaitGroup
+	for i, st := range stations {
+		wg.Add(1)
+		go func(i int, st string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i].Response, results[i].Err = retrieveWithRetries(client, st, address, opts)
+		}(i, st)
+	}
+	wg.Wait()
+	return results
+}
+
+// compassPoints are the names of the 32 point compass, starting at North and
+// going clockwise. The 16, 8 and 4 point compasses use every second, fourth
+// and eighth of them.
+var compassPoints = []string{
+	"North", "North by east", "North-northeast", "Northeast by north",
+	"Northeast", "Northeast by east", "East-northeast", "East by north",
+	"East", "East by south", "East-southeast", "Southeast by east",
+	"Southeast", "Southeast by south", "South-southeast", "South by east",
+	"South", "South by west", "South-southwest", "Southwest by south",
+	"Southwest", "Southwest by west", "West-southwest", "West by south",
+	"West", "West by north", "West-northwest", "Northwest by west",
+	"Northwest", "Northwest by north", "North-northwest", "North by west",
+}
+
+// ValidateCompassPoints returns an error if points is not a compass
+// resolution CardinalDirection supports.
+func ValidateCompassPoints(points int) error {
+	switch points {
+	case 4, 8, 16, 32:
+		return nil
 	default:
-		return "West"
+		return fmt.Errorf("unsupported compass points %d, expected 4, 8, 16 or 32", points)
+	}
+}
+
+// CardinalDirection takes a given degree on a 360 degree axis and returns the
+// name of the nearest direction on a compass with the given number of points,
+// e.g. North with 4 points, Northeast with 8, North-northeast with 16 or
+// North by east with 32. A degree halfway between two points rounds
+// clockwise.
+func CardinalDirection(degree float64, points int) string {
+	degree = math.Mod(degree, 360)
+	if degree < 0 {
+		degree += 360
 	}
+	sector := int(math.Floor(degree/(360/float64(points))+0.5)) % points
+	return compassPoints[sector*len(compassPoints)/points]
 }
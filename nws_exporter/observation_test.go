@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCardinalDirection(t *testing.T) {
+	tests := []struct {
+		points int
+		degree float64
+		want   string
+	}{
+		{4, 0, "North"},
+		{4, 44.9, "North"},
+		{4, 45, "East"},
+		{4, 134.9, "East"},
+		{4, 135, "South"},
+		{4, 224.9, "South"},
+		{4, 225, "West"},
+		{4, 314.9, "West"},
+		{4, 315, "North"},
+		{4, 360, "North"},
+		{4, -90, "West"},
+
+		{8, 22.4, "North"},
+		{8, 22.5, "Northeast"},
+		{8, 67.4, "Northeast"},
+		{8, 67.5, "East"},
+		{8, 337.4, "Northwest"},
+		{8, 337.5, "North"},
+
+		{16, 11.2, "North"},
+		{16, 11.25, "North-northeast"},
+		{16, 33.7, "North-northeast"},
+		{16, 33.75, "Northeast"},
+		{16, 348.7, "North-northwest"},
+		{16, 348.75, "North"},
+
+		{32, 5.6, "North"},
+		{32, 5.625, "North by east"},
+		{32, 16.8, "North by east"},
+		{32, 16.875, "North-northeast"},
+		{32, 354.3, "North by west"},
+		{32, 354.375, "North"},
+		{32, 720, "North"},
+	}
+	for _, tt := range tests {
+		if got := CardinalDirection(tt.degree, tt.points); got != tt.want {
+			t.Errorf("CardinalDirection(%v, %d) = %q, want %q", tt.degree, tt.points, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCompassPoints(t *testing.T) {
+	for _, points := range []int{4, 8, 16, 32} {
+		if err := ValidateCompassPoints(points); err != nil {
+			t.Errorf("ValidateCompassPoints(%d) = %v, want nil", points, err)
+		}
+	}
+	for _, points := range []int{0, 2, 12, 64} {
+		if err := ValidateCompassPoints(points); err == nil {
+			t.Errorf("ValidateCompassPoints(%d) = nil, want an error", points)
+		}
+	}
+}
+
+func TestRetrieveCurrentObservationNullProperties(t *testing.T) {
+	for _, body := range []string{
+		`{"properties": null}`,
+		`{"type": "Feature"}`,
+		`{"properties": {}}`,
+	} {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/geo+json")
+			fmt.Fprint(w, body)
+		}))
+		_, err := RetrieveCurrentObservation(srv.Client(), "KPHL", strings.TrimPrefix(srv.URL, "https://"), ObservationOptions{})
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), "has no properties") {
+			t.Errorf("body %s: err = %v, want no properties", body, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxPrecision bounds the decimal places -precision accepts either way,
+// well past anything NWS measures.
+const maxPrecision = 6
+
+// precisions maps gauges to the number of decimal places setReading rounds
+// them to, in their exported units. Gauges without an entry aren't rounded.
+// It is only written by main before scraping starts.
+var precisions = map[prometheus.Collector]int{}
+
+// ParsePrecision sets precisions from spec, a comma separated list of
+// entries that are either a bare number of decimal places for every reading
+// -deadband covers, or name=places for one of them, which takes precedence,
+// e.g. "1,barometric_pressure=-1". Negative places round to tens, hundreds
+// and so on.
+func ParsePrecision(spec string) error {
+	gauges := readingGauges()
+	named := map[prometheus.Gauge]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		name, places, isNamed := strings.Cut(entry, "=")
+		if !isNamed {
+			places = name
+		}
+		n, err := strconv.Atoi(places)
+		if err != nil || n < -maxPrecision || n > maxPrecision {
+			return fmt.Errorf("invalid precision %q, decimal places must be a whole number between %d and %d", entry, -maxPrecision, maxPrecision)
+		}
+		if !isNamed {
+			for _, g := range gauges {
+				precisions[g] = n
+			}
+			continue
+		}
+		g, ok := gauges[name]
+		if !ok {
+			return fmt.Errorf("unknown precision metric %q, expected one of %s", name, readingNames(gauges))
+		}
+		named[g] = n
+	}
+	for g, n := range named {
+		precisions[g] = n
+	}
+	return nil
+}
+
+// roundReading rounds value to g's -precision, or returns it unchanged if
+// none is set.
+func roundReading(g prometheus.Gauge, value float64) float64 {
+	places, ok := precisions[g]
+	if !ok {
+		return value
+	}
+	// Dividing by the power of ten for positive places, rather than
+	// multiplying by its inverse, keeps e.g. 0.3 from becoming
+	// 0.30000000000000004
+	if places >= 0 {
+		scale := math.Pow(10, float64(places))
+		return math.Round(value*scale) / scale
+	}
+	scale := math.Pow(10, float64(-places))
+	return math.Round(value/scale) * scale
+}
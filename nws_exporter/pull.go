@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pullGatherer scrapes NWS for -pullmode when prometheus scrapes /metrics,
+// before gathering the default registry, so the data is as fresh as the
+// prometheus scrape rather than as the background loop's last pass. It
+// scrapes at most once every interval, and concurrent requests wait for the
+// scrape in progress rather than starting their own.
+type pullGatherer struct {
+	mu       sync.Mutex
+	client   *http.Client
+	ranges   PlausibleRanges
+	interval time.Duration
+	last     time.Time
+}
+
+// Gather implements prometheus.Gatherer.
+func (g *pullGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.mu.Lock()
+	if time.Since(g.last) >= g.interval {
+		// A failed scrape also waits out the interval, so an NWS outage
+		// isn't hit with a request for every prometheus scrape
+		g.last = time.Now()
+		scrapeLoopIterations.Inc()
+		if err := safeScrape(g.client, g.ranges, verbose); err != nil {
+			log.Printf("Problem retrieving from all stations: %v", err)
+		}
+	}
+	g.mu.Unlock()
+	return prometheus.DefaultGatherer.Gather()
+}
+
+// newPullGatherer returns the gatherer for -pullmode, which scrapes on
+// demand at most once every interval.
+func newPullGatherer(client *http.Client, ranges PlausibleRanges, interval time.Duration) prometheus.Gatherer {
+	return &pullGatherer{client: client, ranges: ranges, interval: interval}
+}
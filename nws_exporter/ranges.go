@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Range is an inclusive interval of plausible values for a reading.
+type Range struct {
+	Min, Max float64
+}
+
+// PlausibleRanges maps metric names (without namespace) to the range of
+// values we are willing to export for them. Readings are checked in the units
+// NWS reports them in, before any -units conversion.
+type PlausibleRanges map[string]Range
+
+// defaultRanges are deliberately generous physical bounds, meant only to
+// catch obvious garbage such as a temperature of 999.
+var defaultRanges = PlausibleRanges{
+	"humidity":            {0, 100},
+	"temperature":         {-90, 60},
+	"temperature_max_24h": {-90, 60},
+	"temperature_min_24h": {-90, 60},
+	"dewpoint":            {-90, 40},
+	"wind_direction":      {0, 360},
+	"wind_speed":          {0, 400},
+	"barometric_pressure": {50000, 110000},
+	"sealevel_pressure":   {85000, 110000},
+	"visibility":          {0, 100000},
+
+	"precipitation_last_hour_mm":    {0, 500},
+	"precipitation_last_3_hours_mm": {0, 1000},
+	"precipitation_last_6_hours_mm": {0, 1500},
+}
+
+// Contains reports whether value is plausible for the named metric. Metrics
+// without a configured range are always plausible.
+func (r PlausibleRanges) Contains(name string, value float64) bool {
+	rng, ok := r[name]
+	if !ok {
+		return true
+	}
+	return value >= rng.Min && value <= rng.Max
+}
+
+// ParseRanges returns the default ranges with any overrides from spec
+// applied. spec is a comma separated list of name=min:max entries, e.g.
+// "temperature=-50:50,wind_speed=0:200".
+func ParseRanges(spec string) (PlausibleRanges, error) {
+	ranges := PlausibleRanges{}
+	for name, rng := range defaultRanges {
+		ranges[name] = rng
+	}
+	if spec == "" {
+		return ranges, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		name, bounds, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q, expected name=min:max", entry)
+		}
+		lo, hi, ok := strings.Cut(bounds, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q, expected name=min:max", entry)
+		}
+		min, err := strconv.ParseFloat(lo, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum in range %q: %v", entry, err)
+		}
+		max, err := strconv.ParseFloat(hi, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maximum in range %q: %v", entry, err)
+		}
+		if min > max {
+			return nil, fmt.Errorf("invalid range %q, minimum is greater than maximum", entry)
+		}
+		ranges[name] = Range{Min: min, Max: max}
+	}
+	return ranges, nil
+}
+
+// ParseBuckets parses a comma separated list of increasing histogram bucket
+// upper bounds, e.g. "-10,0,10,20,30".
+func ParseBuckets(spec string) ([]float64, error) {
+	var buckets []float64
+	for _, entry := range strings.Split(spec, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(entry), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %v", entry, err)
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("invalid buckets %q, bounds must be increasing", spec)
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
+}
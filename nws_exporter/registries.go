@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// stationMetrics are the readings of a single station, in a registry of
+// their own so that with -perstationpaths each station can be scraped from
+// /metrics/<station> by a separate prometheus job.
+type stationMetrics struct {
+	registry    *prometheus.Registry
+	temperature prometheus.Gauge
+	dewpoint    prometheus.Gauge
+	humidity    prometheus.Gauge
+	pressure    prometheus.Gauge
+	windspeed   prometheus.Gauge
+}
+
+// stationGaugeOpts are the options of the per-station gauges. registerMetrics
+// sets them so the gauges are named like their counterparts on /metrics.
+var stationGaugeOpts struct {
+	temperature, dewpoint, humidity, pressure, windspeed prometheus.GaugeOpts
+}
+
+// stationRegistries holds the metrics of every station retrieved so far,
+// keyed by upper case station identifier. It is nil unless -perstationpaths
+// is set, which disables recording them.
+var (
+	stationRegistriesMu sync.Mutex
+	stationRegistries   map[string]*stationMetrics
+)
+
+// newStationMetrics constructs the gauges for one station and registers them
+// with a new registry. Each is left out until the station first reports it.
+func newStationMetrics() *stationMetrics {
+	m := &stationMetrics{
+		registry:    prometheus.NewRegistry(),
+		temperature: prometheus.NewGauge(stationGaugeOpts.temperature),
+		dewpoint:    prometheus.NewGauge(stationGaugeOpts.dewpoint),
+		humidity:    prometheus.NewGauge(stationGaugeOpts.humidity),
+		pressure:    prometheus.NewGauge(stationGaugeOpts.pressure),
+		windspeed:   prometheus.NewGauge(stationGaugeOpts.windspeed),
+	}
+	gauges := []prometheus.Collector{m.temperature, m.dewpoint, m.humidity, m.pressure, m.windspeed}
+	for _, g := range gauges {
+		setMissing(g, true)
+	}
+	m.registry.MustRegister(&missingCollector{collectors: gauges})
+	return m
+}
+
+// recordStation sets the per-station gauges of id from its observation. Null
+// readings keep their last value, as the station's own series should not
+// depend on which -onmissing the combined /metrics uses.
+func recordStation(id string, resp ObservationResponse) {
+	stationRegistriesMu.Lock()
+	defer stationRegistriesMu.Unlock()
+	if stationRegistries == nil {
+		return
+	}
+	id = strings.ToUpper(id)
+	m, ok := stationRegistries[id]
+	if !ok {
+		m = newStationMetrics()
+		stationRegistries[id] = m
+	}
+
+	set := func(g prometheus.Gauge, value float64, present bool) {
+		if present {
+			setMissing(g, false)
+			g.Set(value)
+		}
+	}
+	p := resp.Properties
+	set(m.temperature, ConvertTemperature(p.Temperature.Value, units), p.Temperature.Value != 0)
+	set(m.dewpoint, ConvertTemperature(p.Dewpoint.Value, units), p.Dewpoint.Value != 0)
+	// The scrape or zone poll that retrieved the station logs any clamping
+	rh := clampRH(p.RelativeHumidity.Value)
+	set(m.humidity, rh, rh != 0)
+	set(m.pressure, ConvertPressure(p.BarometricPressure.Value, units), p.BarometricPressure.Value != 0)
+	set(m.windspeed, ConvertSpeed(valueOf(p.WindSpeed.Value), units), p.WindSpeed.Value != nil)
+}
+
+// recordStations records every successfully retrieved station in results,
+// which are in the same order as stations.
+func recordStations(stations []string, results []StationResult) {
+	for i, result := range results {
+		if result.Err == nil {
+			recordStation(stations[i], result.Response)
+		}
+	}
+}
+
+// stationMetricsHandler returns the handler serving the registry of the
+// station named by the last path element of /metrics/<station>, restricted
+// to allow like /metrics. It returns 404 for a station that hasn't been
+// retrieved, and lists the known stations at /metrics/.
+func stationMetricsHandler(allow []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/metrics/"))
+
+		stationRegistriesMu.Lock()
+		m, ok := stationRegistries[id]
+		known := make([]string, 0, len(stationRegistries))
+		for st := range stationRegistries {
+			known = append(known, st)
+		}
+		stationRegistriesMu.Unlock()
+
+		if id == "" {
+			sort.Strings(known)
+			writeJSON(w, known)
+			return
+		}
+		if !ok {
+			http.Error(w, "no observation retrieved for station "+id, http.StatusNotFound)
+			return
+		}
+		promhttp.HandlerFor(allowMetrics(m.registry, allow), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
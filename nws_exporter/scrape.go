@@ -0,0 +1,755 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stationMu guards station, latitude and longitude, which /reload can change
+// together while the scrape loop is running.
+var stationMu sync.Mutex
+
+// activeStation returns the primary station currently being scraped.
+func activeStation() string {
+	stationMu.Lock()
+	defer stationMu.Unlock()
+	return station
+}
+
+// activeCoordinates returns the latitude and longitude the sun metrics are
+// currently computed for.
+func activeCoordinates() (lat, lon float64) {
+	stationMu.Lock()
+	defer stationMu.Unlock()
+	return latitude, longitude
+}
+
+// setActiveSite switches the primary station and the sun coordinates used by
+// the next scrape, so a scrape never sees one without the other.
+func setActiveSite(id string, lat, lon float64) {
+	stationMu.Lock()
+	defer stationMu.Unlock()
+	station = id
+	latitude, longitude = lat, lon
+}
+
+// observationOptions returns the ObservationOptions configured by flags.
+func observationOptions() ObservationOptions {
+	opts := ObservationOptions{
+		UseList:          uselist,
+		MaxRetries:       maxretries,
+		MaxResponseBytes: maxresponsebytes,
+		Timeout:          endpointTimeout(observationtimeout),
+		DumpRaw:          dumpraw,
+		FieldMap:         fieldMap,
+		Source:           source,
+	}
+	if mirrors != "" {
+		opts.Mirrors = strings.Split(mirrors, ",")
+	}
+	return opts
+}
+
+// offsetNow returns the current time shifted by -timeoffset, for testing sun
+// transitions without waiting for them.
+func offsetNow() time.Time {
+	return time.Now().Add(timeoffset)
+}
+
+// endpointTimeout returns the given per-endpoint timeout in seconds, or the
+// -timeout default if it is zero.
+func endpointTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = timeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// safeScrape runs scrape, turning a panic into an error so that one bad
+// response can't kill the scrape loop and leave stale metrics behind. Any
+// error is also recorded for the status page.
+func safeScrape(client *http.Client, ranges PlausibleRanges, logDetails bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic during scrape: %v\n%s", r, debug.Stack())
+			recordPanic()
+			err = fmt.Errorf("panic during scrape: %v", r)
+		}
+		if err != nil {
+			recordError(err)
+		}
+	}()
+	return scrape(client, ranges, logDetails)
+}
+
+// panicWindow is the period -maxpanics counts scrape panics over.
+const panicWindow = time.Hour
+
+// recentPanics are the times of the scrape panics within panicWindow. It is
+// only used from the scrape goroutine.
+var recentPanics []time.Time
+
+// recordPanic counts a recovered scrape panic, and exits if there have been
+// more than -maxpanics within panicWindow, so an orchestrator can restart a
+// process that keeps failing rather than it serving stale data.
+func recordPanic() {
+	scrapePanics.Inc()
+	now := time.Now()
+	kept := recentPanics[:0]
+	for _, t := range recentPanics {
+		if now.Sub(t) < panicWindow {
+			kept = append(kept, t)
+		}
+	}
+	recentPanics = append(kept, now)
+	if maxpanics > 0 && len(recentPanics) > maxpanics {
+		log.Fatalf("error: %d scrape panics in the last %v, exiting", len(recentPanics), panicWindow)
+	}
+}
+
+// superviseScrapeLoop runs scrapeLoop, relaunching it if it panics after a
+// backoff that doubles with each restart up to backofftime. It never returns.
+func superviseScrapeLoop(client *http.Client, ranges PlausibleRanges, started bool) {
+	for restarts := 0; ; restarts++ {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Recovered from panic in scrape loop: %v\n%s", r, debug.Stack())
+					recordPanic()
+				}
+			}()
+			scrapeLoop(client, ranges, started)
+		}()
+
+		delay := time.Duration(backofftime) * time.Second
+		if restarts < 16 && retryDelay<<restarts < delay {
+			delay = retryDelay << restarts
+		}
+		log.Printf("Restarting scrape loop in %v: restarts=%d", delay, restarts+1)
+		time.Sleep(delay)
+		started = false
+	}
+}
+
+// scrapeLoop scrapes every backofftime seconds, spread by -jitter. If started
+// is set the first scrape already happened at startup, so it waits before
+// scraping.
+func scrapeLoop(client *http.Client, ranges PlausibleRanges, started bool) {
+	// successful scrapes, used to sample verbose logging
+	scrapes := 0
+	// failed scrapes since the last success, for -maxconsecutivefailures
+	failures := 0
+	if started {
+		scrapes++
+		time.Sleep(scrapeInterval())
+	}
+	for {
+		scrapeLoopIterations.Inc()
+		logDetails := verbose && (logsampling <= 1 || scrapes%logsampling == 0)
+		if err := safeScrape(client, ranges, logDetails); err != nil {
+			if failfast {
+				log.Fatalf("error: %v", err)
+			}
+			failures++
+			if maxfailures > 0 && failures >= maxfailures {
+				log.Fatalf("error: %d scrapes in a row failed, giving up: %v", failures, err)
+			}
+
+			// A station that worked at startup can answer 404 while it has
+			// no recent observation, so only say so rather than exiting
+			if !Retriable(err) {
+				log.Printf("Station %s can't be retrieved and retries were skipped, check it is still reporting: %v", activeStation(), err)
+			} else {
+				log.Printf("Problem retrieving from all stations: %v", err)
+			}
+			interval := scrapeInterval()
+			log.Printf("Waiting %v, next scrape at %s", interval, time.Now().Add(interval))
+			time.Sleep(interval)
+			continue
+		}
+
+		scrapes++
+		failures = 0
+		interval := scrapeInterval()
+		if logDetails {
+			log.Printf("Waiting %v, next scrape at %s", interval, time.Now().Add(interval).String())
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Exit codes for -once.
+const (
+	exitComplete = 0
+	exitFailure  = 1
+	exitPartial  = 2
+)
+
+// startupRetries is the least number of extra scrapes startupScrape makes
+// when the first one fails. A larger -maxretries raises it.
+const startupRetries = 2
+
+// startupScrape tries a short burst of scrapes before /metrics is served, so
+// a Prometheus scrape right after launch finds data. It returns whether any
+// of them succeeded, logging prominently if none did.
+func startupScrape(client *http.Client, ranges PlausibleRanges) bool {
+	retries := startupRetries
+	if maxretries > retries {
+		retries = maxretries
+	}
+	attempts := retries + 1
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = safeScrape(client, ranges, verbose); err == nil {
+			return true
+		}
+		log.Printf("Startup scrape failed: attempt=%d/%d error=%v", attempt, attempts, err)
+		if !Retriable(err) {
+			log.Fatalf("error: station %s can't be retrieved and retrying won't help, check -station: %v", activeStation(), err)
+		}
+		if attempt < attempts {
+			time.Sleep(retryDelay)
+		}
+	}
+	log.Printf("WARNING: starting with no data after %d attempts, /metrics will be empty until a scrape succeeds: %v", attempts, err)
+	return false
+}
+
+// scrapeOnce runs a single scrape for -once and returns the exit code:
+// exitComplete if the primary station reported every expected reading,
+// exitPartial if readings were missing or came from a fallback station, and
+// exitFailure if no station could be retrieved.
+func scrapeOnce(client *http.Client, ranges PlausibleRanges) int {
+	if err := safeScrape(client, ranges, verbose); err != nil {
+		log.Printf("Problem retrieving from all stations: %v", err)
+		return exitFailure
+	}
+	// There is no watchZone running to poll the zone
+	if zone != "" {
+		if err := pollZone(client, zone, observationOptions()); err != nil {
+			log.Printf("Problem retrieving zone %s: %v", zone, err)
+		}
+	}
+	c, _ := LastConditions()
+	if c.Station != activeStation() || (ObservationResponse{Properties: c.Observation}).FieldsPresent() < expectedFields {
+		return exitPartial
+	}
+	return exitComplete
+}
+
+// scrape retrieves the current observation for the primary station, falling
+// back to nearby stations for missing data, and updates every metric from it
+// along with the sun position. It returns an error if no station could be
+// retrieved. If logDetails is set the computed sun position is logged.
+func scrape(client *http.Client, ranges PlausibleRanges, logDetails bool) error {
+	station := activeStation()
+
+	// Fetch the primary and fallback stations, PHHN (Hana) and PHLI
+	// (Lihue), concurrently so a slow station costs one timeout, not N
+	stations := append([]string{station}, fallbackStations...)
+	results := RetrieveObservations(client, stations, address, observationOptions())
+	recordStations(stations, results)
+	primaryResponse, primaryErr := results[0].Response, results[0].Err
+
+	var fallbackResponse ObservationResponse
+	var fallbackErr error
+	fallbackUsed := false
+	fallbackStation := ""
+
+	if lat, lon, ok := ObservationCoordinates(primaryResponse); primaryErr == nil && ok {
+		primaryCoordinates = []float64{lat, lon}
+	}
+
+	// Check if we need fallback data (primary has null or implausible temperature)
+	if primaryErr != nil || primaryResponse.Properties.Temperature.Value == 0 ||
+		!ranges.Contains("temperature", primaryResponse.Properties.Temperature.Value) {
+		for i, tryStation := range fallbackStations {
+			fallbackResponse, fallbackErr = results[i+1].Response, results[i+1].Err
+			if fallbackErr == nil && fallbackResponse.Properties.Temperature.Value != 0 {
+				if distance, ok := fallbackDistance(fallbackResponse); ok && maxfallbackdistance > 0 && distance > maxfallbackdistance {
+					log.Printf("Skipping fallback station %s, %.0fkm from %s is beyond -maxfallbackdistance", tryStation, distance, station)
+					continue
+				}
+				log.Printf("Using fallback station %s for missing data from %s", tryStation, station)
+				fallbackUsed = true
+				fallbackStation = tryStation
+				break
+			}
+		}
+	}
+
+	if primaryErr != nil && (!fallbackUsed || fallbackErr != nil) {
+		return fmt.Errorf("station=%s fallbacks=%v: %w", station, fallbackStations, primaryErr)
+	}
+
+	// Helper function to get value from primary or fallback, skipping
+	// readings outside the plausible range for the metric
+	getValue := func(name string, primaryVal, fallbackVal float64) float64 {
+		if primaryErr == nil && primaryVal != 0 {
+			if ranges.Contains(name, primaryVal) {
+				return primaryVal
+			}
+			log.Printf("Warning: ignoring implausible %s %v from %s", name, primaryVal, station)
+		}
+		if fallbackUsed && fallbackVal != 0 {
+			if ranges.Contains(name, fallbackVal) {
+				fallbackFields.WithLabelValues(name, fallbackStation).Set(1)
+				return fallbackVal
+			}
+			log.Printf("Warning: ignoring implausible %s %v from fallback station", name, fallbackVal)
+		}
+		return 0
+	}
+	// getReading is getValue for readings where zero is a real value, such
+	// as no rain, so only a null reading counts as missing
+	getReading := func(name string, primaryVal, fallbackVal *float64) (float64, bool) {
+		if primaryErr == nil && primaryVal != nil {
+			if ranges.Contains(name, *primaryVal) {
+				return *primaryVal, true
+			}
+			log.Printf("Warning: ignoring implausible %s %v from %s", name, *primaryVal, station)
+		}
+		if fallbackUsed && fallbackVal != nil {
+			if ranges.Contains(name, *fallbackVal) {
+				fallbackFields.WithLabelValues(name, fallbackStation).Set(1)
+				return *fallbackVal, true
+			}
+			log.Printf("Warning: ignoring implausible %s %v from fallback station", name, *fallbackVal)
+		}
+		return 0, false
+	}
+	fallbackFields.Reset()
+
+	observedAt := primaryResponse.Properties.Timestamp
+	if primaryErr != nil {
+		observedAt = fallbackResponse.Properties.Timestamp
+	}
+	newObservation := !observedAt.Equal(ObservationTime())
+
+	if primaryErr == nil {
+		if newObservation && lastPrimary != nil && SameReadings(*lastPrimary, primaryResponse.Properties) {
+			log.Printf("Warning: observation from %s at %s has the same readings as the previous one", station, observedAt)
+			duplicateObs.Inc()
+		}
+		lastPrimary = &primaryResponse.Properties
+		SetObservationTime(primaryResponse.Properties.Timestamp)
+		fieldsPresent.Set(float64(primaryResponse.FieldsPresent()))
+		if elevation := primaryResponse.Properties.Elevation.Value; elevation > 0 {
+			SetObserverElevation(float64(elevation))
+		}
+	} else {
+		SetObservationTime(fallbackResponse.Properties.Timestamp)
+		fieldsPresent.Set(0)
+	}
+
+	// Set metrics, preferring primary station data
+	// Only clamp, and so log, the readings that can be exported
+	var primaryRH, fallbackRH float64
+	if primaryErr == nil {
+		primaryRH = clampHumidity(station, primaryResponse.Properties.RelativeHumidity.Value)
+	}
+	if fallbackUsed {
+		fallbackRH = clampHumidity(fallbackStation, fallbackResponse.Properties.RelativeHumidity.Value)
+	}
+	rh := getValue("humidity", primaryRH, fallbackRH)
+	setReading(humidity, rh, rh != 0)
+	tempC := getValue("temperature", primaryResponse.Properties.Temperature.Value, fallbackResponse.Properties.Temperature.Value)
+	setReading(temperature, ConvertTemperature(tempC, units), tempC != 0)
+	if tempC != 0 {
+		// Only count each observation once, however many times it is scraped
+		if newObservation {
+			temperatureDist.Observe(ConvertTemperature(tempC, units))
+		}
+	}
+	val := getValue("temperature_max_24h", primaryResponse.Properties.MaxTemperatureLast24Hours.Value, fallbackResponse.Properties.MaxTemperatureLast24Hours.Value)
+	setReading(temperatureMax24h, ConvertTemperature(val, units), val != 0)
+	val = getValue("temperature_min_24h", primaryResponse.Properties.MinTemperatureLast24Hours.Value, fallbackResponse.Properties.MinTemperatureLast24Hours.Value)
+	setReading(temperatureMin24h, ConvertTemperature(val, units), val != 0)
+	dewpointC := getValue("dewpoint", primaryResponse.Properties.Dewpoint.Value, fallbackResponse.Properties.Dewpoint.Value)
+	setReading(dewpoint, ConvertTemperature(dewpointC, units), dewpointC != 0)
+	windDir := getValue("wind_direction", valueOf(primaryResponse.Properties.WindDirection.Value), valueOf(fallbackResponse.Properties.WindDirection.Value))
+	if windDir != 0 {
+		// Only the current direction's series is exported
+		winddirection.Reset()
+		winddirection.WithLabelValues(CardinalDirection(windDir, compasspoints)).Set(windDir)
+	} else {
+		clearReadings(winddirection)
+	}
+	windKmh := getValue("wind_speed", valueOf(primaryResponse.Properties.WindSpeed.Value), valueOf(fallbackResponse.Properties.WindSpeed.Value))
+	setReading(windspeed, ConvertSpeed(windKmh, units), windKmh != 0)
+
+	// reporting is the station the readings below that distinguish null from
+	// zero come from
+	reporting := primaryResponse
+	if primaryErr != nil && fallbackUsed {
+		reporting = fallbackResponse
+	}
+	reportingID := StationIdentifier(reporting.Properties.Station)
+	if primaryErr == nil && !strings.EqualFold(reportingID, station) {
+		log.Printf("Warning: requested station %s but the observation came from %s", station, reportingID)
+	}
+	reportingStation.Reset()
+	reportingStation.WithLabelValues(station, reportingID).Set(1)
+	// Calm wind is reported as a zero speed with a null direction, which the
+	// zero-is-missing handling above would otherwise drop
+	if reporting.IsCalm() {
+		windKmh = 0
+		setReading(windspeed, 0, true)
+		setReading(windCalm, 1, true)
+	} else {
+		setReading(windCalm, 0, reporting.Properties.WindSpeed.Value != nil)
+	}
+	// Export the wind as a vector too, since directions can't be averaged
+	// as plain degrees
+	if reporting.IsCalm() {
+		setReading(windU, 0, true)
+		setReading(windV, 0, true)
+	} else {
+		u, v := WindComponents(windKmh, windDir)
+		setReading(windU, ConvertSpeed(u, units), windKmh != 0 && windDir != 0)
+		setReading(windV, ConvertSpeed(v, units), windKmh != 0 && windDir != 0)
+	}
+	stationPa := getValue("barometric_pressure", primaryResponse.Properties.BarometricPressure.Value, fallbackResponse.Properties.BarometricPressure.Value)
+	setReading(barometricpressure, ConvertPressure(stationPa, units), stationPa != 0)
+	// Many stations only report station pressure, so when sea level pressure
+	// is missing reduce it ourselves from the station elevation. An unknown
+	// elevation is zero, which would pass the station pressure off as the
+	// sea level pressure, so then it stays missing
+	seaLevelPa := getValue("sealevel_pressure", primaryResponse.Properties.SeaLevelPressure.Value, fallbackResponse.Properties.SeaLevelPressure.Value)
+	seaLevelSource := "reported"
+	if seaLevelPa == 0 && stationPa != 0 {
+		pressureResponse := primaryResponse
+		if primaryErr != nil || primaryResponse.Properties.BarometricPressure.Value == 0 {
+			pressureResponse = fallbackResponse
+		}
+		airTempC := tempC
+		if airTempC == 0 {
+			airTempC = 15 // standard atmosphere
+		}
+		if elevation := pressureResponse.Properties.Elevation.Value; elevation != 0 {
+			computed := SeaLevelPressure(stationPa, float64(elevation), airTempC)
+			if ranges.Contains("sealevel_pressure", computed) {
+				seaLevelPa, seaLevelSource = computed, "computed"
+			}
+		}
+	}
+	if seaLevelPa != 0 {
+		sealevelpressure.Reset()
+		sealevelpressure.WithLabelValues(seaLevelSource).Set(ConvertPressure(seaLevelPa, units))
+	} else {
+		clearReadings(sealevelpressure)
+	}
+	setPressureReadings(pressure, stationPa)
+	setPressureReadings(pressureSealevel, seaLevelPa, seaLevelSource)
+	val = getValue("visibility", primaryResponse.Properties.Visibility.Value, fallbackResponse.Properties.Visibility.Value)
+	setReading(visibility, ConvertDistance(val, units), val != 0)
+	unlimited := 0.0
+	if val >= unlimitedVisibility {
+		unlimited = 1
+	}
+	setReading(visibilityUnlim, unlimited, val != 0)
+
+	mm, present := getReading("precipitation_last_hour_mm", primaryResponse.Properties.PrecipitationLastHour.Value, fallbackResponse.Properties.PrecipitationLastHour.Value)
+	setReading(precipitation1h, mm, present)
+	mm, present = getReading("precipitation_last_3_hours_mm", primaryResponse.Properties.PrecipitationLast3Hours.Value, fallbackResponse.Properties.PrecipitationLast3Hours.Value)
+	setReading(precipitation3h, mm, present)
+	mm, present = getReading("precipitation_last_6_hours_mm", primaryResponse.Properties.PrecipitationLast6Hours.Value, fallbackResponse.Properties.PrecipitationLast6Hours.Value)
+	setReading(precipitation6h, mm, present)
+
+	if smoother != nil {
+		setSmoothedMetrics(rh, tempC, dewpointC, windKmh, stationPa)
+	}
+
+	// Cloud cover - always prefer primary station (PHOG)
+	var cloudLayers []CloudLayer
+	if primaryErr == nil && len(primaryResponse.Properties.CloudLayers) > 0 {
+		cloudLayers = primaryResponse.Properties.CloudLayers
+	} else if fallbackUsed && len(fallbackResponse.Properties.CloudLayers) > 0 {
+		cloudLayers = fallbackResponse.Properties.CloudLayers
+		fallbackFields.WithLabelValues("cloud_layers", fallbackStation).Set(1)
+	}
+	cloudlayercount.Set(float64(len(cloudLayers)))
+	// Reset so layers that have cleared don't linger, and report an
+	// explicit clear sky rather than no series at all
+	cloudcover.Reset()
+	if len(cloudLayers) == 0 {
+		cloudcover.WithLabelValues("CLR").Set(0)
+	}
+	for _, layer := range cloudLayers {
+		baseHeight := 0.0
+		if layer.Base.Value != 0 {
+			baseHeight = float64(layer.Base.Value)
+		}
+		cloudcover.WithLabelValues(layer.Amount).Set(ConvertHeight(baseHeight, units))
+	}
+
+	// Calculate and set sun position, unless disabled with -nosun
+	var sunPos *SunPosition
+	irradiance := 0.0
+	if !nosun {
+		pos := CalculateSunPosition(offsetNow())
+		setSunMetrics(pos)
+		irradiance = EstimateIrradiance(pos.Altitude, cloudLayers)
+		solarIrradiance.Set(irradiance)
+		sunPos = &pos
+	}
+	// Prefer the heat index and wind chill NWS reports, which it only does
+	// when they apply, and otherwise compute them when they would
+	heatIndex.Reset()
+	if v := reporting.Properties.HeatIndex.Value; v != nil {
+		heatIndex.WithLabelValues("reported").Set(ConvertTemperature(*v, units))
+	} else if tempC != 0 && heatIndexApplies(tempC, rh) {
+		heatIndex.WithLabelValues("computed").Set(ConvertTemperature(HeatIndex(tempC, rh), units))
+	}
+	windChill.Reset()
+	if v := reporting.Properties.WindChill.Value; v != nil {
+		windChill.WithLabelValues("reported").Set(ConvertTemperature(*v, units))
+	} else if tempC != 0 && windChillApplies(tempC, windKmh) {
+		windChill.WithLabelValues("computed").Set(ConvertTemperature(WindChill(tempC, windKmh), units))
+	}
+	setReading(apparentTemp, ConvertTemperature(ApparentTemperature(tempC, rh, windKmh), units), tempC != 0)
+	setReading(thswIndex, ConvertTemperature(THSWIndex(tempC, rh, windKmh, irradiance), units), tempC != 0 && rh != 0)
+	base, present := CloudBase(tempC, dewpointC)
+	setReading(cloudBase, ConvertHeight(base, units), present)
+
+	conditions := Conditions{
+		Station:     station,
+		ScrapedAt:   time.Now(),
+		Observation: primaryResponse.Properties,
+		Sun:         sunPos,
+	}
+	if primaryErr != nil {
+		conditions.Station = StationIdentifier(fallbackResponse.Properties.Station)
+		conditions.Observation = fallbackResponse.Properties
+	}
+	SetLastConditions(conditions)
+	if dataLog != nil && newObservation {
+		if err := dataLog.Write(conditions); err != nil {
+			log.Printf("Problem writing to data log %s: %v", datalog, err)
+		}
+	}
+
+	// Prefer the computed sun position to tell day from night, since the
+	// icon's own day/night refers to when the observation was taken
+	daylight := !strings.Contains(conditions.Observation.Icon, "/night/")
+	if sunPos != nil {
+		daylight = sunPos.IsDaylight
+	}
+	weatherIcon.Reset()
+	weatherIcon.WithLabelValues(WeatherIcon(conditions.Observation.Icon, conditions.Observation.TextDescription, daylight)).Set(1)
+	conditionCode.Reset()
+	if code, daypart := ParseIcon(conditions.Observation.Icon); code != "" {
+		conditionCode.WithLabelValues(code, daypart, conditions.Observation.Icon).Set(1)
+	}
+
+	if historyhours > 0 && newObservation && primaryErr == nil {
+		if err := pollHistory(client, station); err != nil {
+			log.Printf("Problem retrieving observation history for %s: %v", station, err)
+			recordError(fmt.Errorf("history for %s: %w", station, err))
+		}
+	}
+
+	if forecast {
+		if err := pollForecast(client); err != nil {
+			log.Printf("Problem retrieving forecast: %v", err)
+			recordError(fmt.Errorf("forecast: %w", err))
+		}
+	}
+
+	if logDetails && sunPos != nil {
+		log.Printf("Sun: alt=%.1f°, az=%.1f°, daylight=%v", sunPos.Altitude, sunPos.Azimuth, sunPos.IsDaylight)
+		log.Printf("Sunrise: %s, Sunset: %s", sunPos.Sunrise.Format("2006-01-02 15:04 MST"), sunPos.Sunset.Format("2006-01-02 15:04 MST"))
+	}
+	return nil
+}
+
+// clampHumidity clamps a relative humidity reading to [0, 100], logging when
+// it had to. Quality control quirks occasionally report slightly over 100% or
+// below 0%, which would otherwise skew the derived metrics. It should only be
+// called once per reading per scrape, where the reading is exported, and
+// clampRH used everywhere else so the log isn't repeated.
+func clampHumidity(source string, rh float64) float64 {
+	if clamped := clampRH(rh); clamped != rh {
+		log.Printf("Clamping humidity %v from %s to %v", rh, source, clamped)
+		return clamped
+	}
+	return rh
+}
+
+// clampRH clamps a relative humidity reading to [0, 100] without logging.
+func clampRH(rh float64) float64 {
+	return math.Max(0, math.Min(100, rh))
+}
+
+// Strategies for -onmissing, applied when an observation lacks a reading.
+const (
+	missingKeep  = "keep"
+	missingZero  = "zero"
+	missingNaN   = "nan"
+	missingClear = "clear"
+)
+
+// ValidateMissing returns an error if strategy is not an -onmissing value.
+func ValidateMissing(strategy string) error {
+	switch strategy {
+	case missingKeep, missingZero, missingNaN, missingClear:
+		return nil
+	default:
+		return fmt.Errorf("unknown missing value strategy %q, expected %s, %s, %s or %s", strategy, missingKeep, missingZero, missingNaN, missingClear)
+	}
+}
+
+// setReading sets g to value, rounded to g's -precision, if the reading is
+// present and has moved by more than g's -deadband. A missing reading applies
+// the -onmissing strategy instead: keep the last value, set it to zero or
+// NaN, or leave it out of /metrics until the reading returns.
+func setReading(g prometheus.Gauge, value float64, present bool) {
+	if present {
+		setMissing(g, false)
+		value = roundReading(g, value)
+		if !withinDeadband(g, value) {
+			g.Set(value)
+		}
+		return
+	}
+	switch onmissing {
+	case missingZero:
+		setMissing(g, false)
+		g.Set(0)
+	case missingNaN:
+		setMissing(g, false)
+		g.Set(math.NaN())
+	case missingClear:
+		setMissing(g, true)
+	}
+}
+
+// setPressureReadings sets a pressure in pascals on v in every one of
+// pressureUnits, labelled with the unit followed by labels. Like the other
+// labelled gauges, a missing pressure is handled by clearReadings.
+func setPressureReadings(v *prometheus.GaugeVec, pascals float64, labels ...string) {
+	if pascals == 0 {
+		clearReadings(v)
+		return
+	}
+	v.Reset()
+	for _, u := range pressureUnits {
+		v.WithLabelValues(append([]string{u.name}, labels...)...).Set(pascals * u.perPascal)
+	}
+}
+
+// clearReadings applies -onmissing to a labelled gauge whose reading is
+// missing. The labels describe the reading, so with any strategy but keep
+// its series are removed.
+func clearReadings(v *prometheus.GaugeVec) {
+	if onmissing != missingKeep {
+		v.Reset()
+	}
+}
+
+// setSmoothedMetrics folds the latest readings into their moving averages and
+// updates the smoothed gauges. Missing readings leave the average unchanged.
+func setSmoothedMetrics(rh, tempC, dewpointC, windKmh, stationPa float64) {
+	if rh != 0 {
+		humiditySmoothed.Set(smoother.Update("humidity", rh))
+	}
+	if tempC != 0 {
+		temperatureSmoothed.Set(ConvertTemperature(smoother.Update("temperature", tempC), units))
+	}
+	if dewpointC != 0 {
+		dewpointSmoothed.Set(ConvertTemperature(smoother.Update("dewpoint", dewpointC), units))
+	}
+	if windKmh != 0 {
+		windspeedSmoothed.Set(ConvertSpeed(smoother.Update("wind_speed", windKmh), units))
+	}
+	if stationPa != 0 {
+		barometricpressureSmoothed.Set(ConvertPressure(smoother.Update("barometric_pressure", stationPa), units))
+	}
+}
+
+// fallbackStations are tried in order for readings the primary station is
+// missing.
+var fallbackStations = []string{"PHHN", "PHLI"}
+
+// primaryCoordinates is the latitude and longitude of the primary station
+// from its last observation, to measure fallback stations against even when
+// the primary can't be reached.
+var primaryCoordinates []float64
+
+// fallbackDistance returns the distance in kilometers from the primary
+// station to the station of a fallback observation, or false if either
+// location is unknown.
+func fallbackDistance(fallback ObservationResponse) (float64, bool) {
+	lat, lon, ok := ObservationCoordinates(fallback)
+	if !ok || primaryCoordinates == nil {
+		return 0, false
+	}
+	return Haversine(primaryCoordinates[0], primaryCoordinates[1], lat, lon), true
+}
+
+// lastPrimary is the last observation scraped from the primary station, to
+// spot new observations with exactly the same readings.
+var lastPrimary *ObservationProperties
+
+// insolationSunrise is the sunrise the daily insolation was last computed
+// for, so it is only integrated once a day.
+var insolationSunrise time.Time
+
+// maxAltitudeKey is the local date and coordinates the maximum altitude was
+// last computed for, so it is only recomputed when the date rolls over or
+// /reload moves the coordinates.
+var maxAltitudeKey string
+
+// setSunMetrics updates the sun gauges from the given position.
+func setSunMetrics(sunPos SunPosition) {
+	sunAltitude.Set(sunPos.Altitude)
+	sunAzimuth.Set(sunPos.Azimuth)
+	sunHourAngle.Set(sunPos.HourAngle)
+	sunDistanceAU.Set(sunPos.Distance)
+	sunAngularDiam.Set(sunPos.AngularDiameter)
+	sunAltitudeRate.Set(sunPos.AltitudeRate)
+	sunIncidence.Set(sunPos.AngleOfIncidence)
+	sunApparentSolarTime.Set(sunPos.ApparentSolarTime)
+	sunSeason.Reset()
+	sunSeason.WithLabelValues(sunPos.Season).Set(1)
+	sunDaysToSolstice.Set(sunPos.DaysToSolstice)
+	sunDaysToEquinox.Set(sunPos.DaysToEquinox)
+	sunJulianDay.Set(sunPos.JulianDay)
+	sunSiderealTime.Set(sunPos.SiderealTime)
+	sunAzimuthCardinal.Reset()
+	sunAzimuthCardinal.WithLabelValues(CardinalDirection(sunPos.Azimuth, compasspoints)).Set(1)
+	if sunPos.IsDaylight {
+		sunIsDaylight.Set(1)
+	} else {
+		sunIsDaylight.Set(0)
+	}
+	if sunPos.BetweenRiseSet {
+		sunBetweenRiseSet.Set(1)
+	} else {
+		sunBetweenRiseSet.Set(0)
+	}
+	if !sunPos.Sunrise.IsZero() {
+		sunSunrise.Set(float64(sunPos.Sunrise.Unix()))
+	}
+	if !sunPos.Sunset.IsZero() {
+		sunSunset.Set(float64(sunPos.Sunset.Unix()))
+	}
+	if !sunPos.Sunrise.Equal(insolationSunrise) {
+		sunDailyInsolation.Set(DailyInsolation(sunPos.Sunrise, sunPos.Sunset))
+		insolationSunrise = sunPos.Sunrise
+	}
+	now := offsetNow()
+	lat, lon := activeCoordinates()
+	if key := fmt.Sprintf("%s %.4f,%.4f", now.In(localZone).Format("2006-01-02"), lat, lon); key != maxAltitudeKey {
+		sunMaxAltitude.Set(MaxAltitude(now))
+		maxAltitudeKey = key
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// volatileMetrics depend on the wall clock or the network rather than the
+// observation, so they are left out of the golden files.
+var volatileMetrics = map[string]bool{
+	"nws_http_phase_seconds":        true,
+	"nws_observation_stale_seconds": true,
+}
+
+// observationMetrics returns the lines of a /metrics body for the nws_
+// metric families, leaving out volatileMetrics.
+func observationMetrics(body string) string {
+	var out strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		name := line
+		if strings.HasPrefix(line, "# ") {
+			// # HELP name ... or # TYPE name ...
+			if fields := strings.Fields(line); len(fields) > 2 {
+				name = fields[2]
+			}
+		}
+		name = strings.FieldsFunc(name, func(r rune) bool { return r == '{' || r == ' ' })[0]
+		family := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if strings.HasPrefix(name, "nws_") && !volatileMetrics[name] && !volatileMetrics[family] {
+			out.WriteString(line + "\n")
+		}
+	}
+	return out.String()
+}
+
+// scrapeFixture scrapes the observation in testdata/fixture as the primary
+// station from a fake NWS api, with no fallback stations and no sun metrics,
+// into freshly registered metrics, and returns the resulting /metrics body.
+func scrapeFixture(t *testing.T, fixture string) string {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stations/PHOG/observations/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	savedRegisterer, savedGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	savedAddress, savedFallbacks, savedNoSun, savedLastPrimary := address, fallbackStations, nosun, lastPrimary
+	savedStation, savedObservationTime := activeStation(), ObservationTime()
+	defer func() {
+		SetObservationTime(savedObservationTime)
+		prometheus.DefaultRegisterer, prometheus.DefaultGatherer = savedRegisterer, savedGatherer
+		address, fallbackStations, nosun, lastPrimary = savedAddress, savedFallbacks, savedNoSun, savedLastPrimary
+		setActiveSite(savedStation, latitude, longitude)
+	}()
+
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer, prometheus.DefaultGatherer = reg, reg
+	registerMetrics(MetricsOptions{Namespace: namespace, SunNamespace: sunNamespace, SolarNamespace: solarNamespace, NoSun: true, Units: unitsMetric})
+	address = strings.TrimPrefix(srv.URL, "https://")
+	fallbackStations, nosun, lastPrimary = nil, true, nil
+	// So the fixture is a new observation however often the test runs
+	SetObservationTime(time.Time{})
+	setActiveSite("PHOG", latitude, longitude)
+
+	ranges, err := ParseRanges("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scrape(srv.Client(), ranges, false); err != nil {
+		t.Fatal(err)
+	}
+	return get(t, metricsHandler(reg, nil), "/metrics")
+}
+
+func TestScrapeGolden(t *testing.T) {
+	for _, fixture := range []string{"observation_phog.json", "observation_phog_no_sealevel.json", "observation_phog_no_elevation.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			got := observationMetrics(scrapeFixture(t, fixture))
+			golden := filepath.Join("testdata", strings.TrimSuffix(fixture, ".json")+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run go test -update to create it", err)
+			}
+			if !bytes.Equal([]byte(got), want) {
+				t.Errorf("/metrics for %s differs from %s, run go test -update if the change is intended:\n%s", fixture, golden, got)
+			}
+		})
+	}
+}
+
+func TestClampRH(t *testing.T) {
+	for rh, want := range map[float64]float64{-0.5: 0, 0: 0, 55.2: 55.2, 100: 100, 100.4: 100} {
+		if got := clampRH(rh); got != want {
+			t.Errorf("clampRH(%v) = %v, want %v", rh, got, want)
+		}
+	}
+}
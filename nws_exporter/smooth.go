@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+// Smoother keeps an exponential moving average per metric name across
+// scrapes, for stations whose readings are jittery.
+type Smoother struct {
+	alpha  float64
+	values map[string]float64
+}
+
+// NewSmoother returns a Smoother with the given smoothing factor. An alpha
+// close to 1 follows new readings closely, close to 0 smooths heavily.
+func NewSmoother(alpha float64) (*Smoother, error) {
+	if alpha <= 0 || alpha > 1 {
+		return nil, fmt.Errorf("smoothing factor must be in (0, 1], got %v", alpha)
+	}
+	return &Smoother{alpha: alpha, values: map[string]float64{}}, nil
+}
+
+// Update folds value into the named average and returns the new average. The
+// first value seen for a name is returned unchanged.
+func (s *Smoother) Update(name string, value float64) float64 {
+	prev, ok := s.values[name]
+	if ok {
+		value = s.alpha*value + (1-s.alpha)*prev
+	}
+	s.values[name] = value
+	return value
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// clearSkyIrradiance is the approximate global horizontal irradiance in
+// watts per square meter with the sun directly overhead on a clear day.
+const clearSkyIrradiance = 1000.0
+
+// cloudCoverFactors maps METAR sky cover codes to the rough fraction of
+// clear-sky irradiance that makes it through a layer of that amount.
+var cloudCoverFactors = map[string]float64{
+	"SKC": 1.0,
+	"CLR": 1.0,
+	"FEW": 0.9,
+	"SCT": 0.75,
+	"BKN": 0.5,
+	"OVC": 0.25,
+	"VV":  0.2,
+}
+
+// CloudCoverFactor returns the irradiance attenuation for the worst (most
+// opaque) of the given cloud layers. No layers means a clear sky.
+func CloudCoverFactor(layers []CloudLayer) float64 {
+	factor := 1.0
+	for _, layer := range layers {
+		if f, ok := cloudCoverFactors[layer.Amount]; ok && f < factor {
+			factor = f
+		}
+	}
+	return factor
+}
+
+// EstimateIrradiance estimates the irradiance on a horizontal surface from
+// the sun's altitude in degrees using a simple clear-sky model, scaled down by
+// the reported cloud cover. It is zero while the sun is below the horizon.
+func EstimateIrradiance(altitude float64, layers []CloudLayer) float64 {
+	ghi := clearSkyIrradiance * math.Sin(altitude*math.Pi/180.0)
+	if ghi < 0 {
+		return 0
+	}
+	return ghi * CloudCoverFactor(layers)
+}
+
+// Orientation of a fixed solar panel, set with -paneltilt and
+// -panelazimuth. The default is flat on the ground.
+var (
+	panelTilt    = 0.0   // degrees from horizontal
+	panelAzimuth = 180.0 // degrees from North the panel faces
+)
+
+// AngleOfIncidence returns the angle in degrees between the sun and the
+// normal of a panel tilted tilt degrees from horizontal and facing
+// panelAz degrees from North, given the sun's altitude and azimuth. Above 90
+// degrees the sun is behind the panel.
+func AngleOfIncidence(altitude, azimuth, tilt, panelAz float64) float64 {
+	toRad := math.Pi / 180.0
+	cosTheta := math.Sin(altitude*toRad)*math.Cos(tilt*toRad) +
+		math.Cos(altitude*toRad)*math.Sin(tilt*toRad)*math.Cos((azimuth-panelAz)*toRad)
+	return math.Acos(math.Max(-1, math.Min(1, cosTheta))) / toRad
+}
+
+// insolationStep is the interval DailyInsolation samples the sun's altitude
+// at.
+const insolationStep = 5 * time.Minute
+
+// DailyInsolation integrates the clear-sky irradiance model between sunrise
+// and sunset, sampling the sun's altitude every insolationStep, and returns
+// the day's solar energy on a horizontal surface in kWh/m². It is zero if
+// the sun doesn't rise and set that day.
+func DailyInsolation(sunrise, sunset time.Time) float64 {
+	if sunrise.IsZero() || sunset.IsZero() {
+		return 0
+	}
+	latitude, longitude := activeCoordinates()
+	wattHours := 0.0
+	for t := sunrise; t.Before(sunset); t = t.Add(insolationStep) {
+		step := insolationStep
+		if remaining := sunset.Sub(t); remaining < step {
+			step = remaining
+		}
+		// Sample the middle of the step
+		alt, _, _ := sunPosition(toJulianDay(t.Add(step/2).UTC()), latitude, longitude)
+		wattHours += EstimateIrradiance(alt, nil) * step.Hours()
+	}
+	return wattHours / 1000
+}
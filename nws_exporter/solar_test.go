@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAngleOfIncidence(t *testing.T) {
+	tests := []struct {
+		name                        string
+		altitude, azimuth, tilt, az float64
+		want                        float64
+	}{
+		{"facing the sun", 30, 180, 60, 180, 0},
+		{"facing the sun in the east", 10, 95, 80, 95, 0},
+		{"facing the zenith sun", 90, 0, 0, 180, 0},
+		{"horizontal, sun at 30°", 30, 180, 0, 180, 60},
+		{"horizontal, sun at 75°", 75, 250, 0, 180, 15},
+		{"horizontal, sun on the horizon", 0, 90, 0, 180, 90},
+		{"vertical, sun on the horizon behind it", 0, 0, 90, 180, 180},
+		{"vertical, sun on the horizon to the side", 0, 90, 90, 180, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AngleOfIncidence(tt.altitude, tt.azimuth, tt.tilt, tt.az); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("AngleOfIncidence(%v, %v, %v, %v) = %v, want %v", tt.altitude, tt.azimuth, tt.tilt, tt.az, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRecentErrors is the number of errors the status page lists.
+const maxRecentErrors = 10
+
+// RecentError is an error from a scrape, or from one of the optional polls
+// run alongside it, and when it happened.
+type RecentError struct {
+	Time    time.Time
+	Message string
+}
+
+// recentErrors holds the last maxRecentErrors errors, oldest first.
+var recentErrors struct {
+	sync.Mutex
+	errs []RecentError
+}
+
+// recordError adds err to the errors shown on the status page, dropping the
+// oldest once there are maxRecentErrors.
+func recordError(err error) {
+	recentErrors.Lock()
+	defer recentErrors.Unlock()
+	recentErrors.errs = append(recentErrors.errs, RecentError{Time: time.Now(), Message: err.Error()})
+	if len(recentErrors.errs) > maxRecentErrors {
+		recentErrors.errs = recentErrors.errs[len(recentErrors.errs)-maxRecentErrors:]
+	}
+}
+
+// RecentErrors returns the recorded errors, newest first.
+func RecentErrors() []RecentError {
+	recentErrors.Lock()
+	defer recentErrors.Unlock()
+	errs := make([]RecentError, len(recentErrors.errs))
+	for i, e := range recentErrors.errs {
+		errs[len(errs)-1-i] = e
+	}
+	return errs
+}
+
+// statusRow is one labelled value on the status page.
+type statusRow struct {
+	Name  string
+	Value string
+}
+
+// statusPage is the data the status page template is rendered from.
+type statusPage struct {
+	Refresh     int
+	Station     string
+	ScrapedAt   string
+	ObservedAt  string
+	Description string
+	Readings    []statusRow
+	Sun         []statusRow
+	Errors      []RecentError
+	Scraped     bool
+}
+
+// statusTemplate renders the status page, which refreshes itself every
+// Refresh seconds.
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>nws_exporter{{if .Station}} {{.Station}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td { padding: 0.2em 1em 0.2em 0; }
+td:first-child { color: #666; }
+</style>
+</head>
+<body>
+<h1>nws_exporter</h1>
+{{if .Scraped}}
+<p>{{.Station}}{{if .Description}}: {{.Description}}{{end}}<br>
+Observed {{.ObservedAt}}, last scraped {{.ScrapedAt}}</p>
+<h2>Observation</h2>
+<table>
+{{range .Readings}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{if .Sun}}<h2>Sun</h2>
+<table>
+{{range .Sun}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}
+{{else}}
+<p>No observation scraped yet.</p>
+{{end}}
+{{if .Errors}}<h2>Recent errors</h2>
+<table>
+{{range .Errors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05 MST"}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{end}}
+<p><a href="/metrics">metrics</a> &middot; <a href="/api/conditions">conditions</a> &middot; <a href="/sun">sun</a></p>
+</body>
+</html>
+`))
+
+// statusTimeFormat is how times are shown on the status page, in the local
+// timezone of the sun coordinates.
+const statusTimeFormat = "2006-01-02 15:04 MST"
+
+// formatReading formats a reading with its unit, or "n/a" if it is missing.
+func formatReading(value float64, present bool, format string) string {
+	if !present {
+		return "n/a"
+	}
+	return fmt.Sprintf(format, value)
+}
+
+// observationRows formats the readings of p in the configured -units.
+func observationRows(p ObservationProperties) []statusRow {
+	tempFormat, speedFormat, pressureFormat, distanceFormat := "%.1f °C", "%.0f km/h", "%.1f hPa", "%.1f km"
+	pressureScale, distanceScale := 0.01, 0.001
+	if units == unitsImperial {
+		tempFormat, speedFormat, pressureFormat, distanceFormat = "%.1f °F", "%.0f mph", "%.2f inHg", "%.1f mi"
+		pressureScale, distanceScale = 1, 1
+	}
+
+	wind := formatReading(ConvertSpeed(valueOf(p.WindSpeed.Value), units), p.WindSpeed.Value != nil, speedFormat)
+	if p.WindDirection.Value != nil {
+		wind += " from " + CardinalDirection(*p.WindDirection.Value, compasspoints)
+	}
+	// The scrape already logged any clamping, so don't repeat it on every render
+	rh := clampRH(p.RelativeHumidity.Value)
+	return []statusRow{
+		{"Temperature", formatReading(ConvertTemperature(p.Temperature.Value, units), p.Temperature.Value != 0, tempFormat)},
+		{"Dewpoint", formatReading(ConvertTemperature(p.Dewpoint.Value, units), p.Dewpoint.Value != 0, tempFormat)},
+		{"Humidity", formatReading(rh, rh != 0, "%.0f%%")},
+		{"Wind", wind},
+		{"Pressure", formatReading(ConvertPressure(p.BarometricPressure.Value, units)*pressureScale, p.BarometricPressure.Value != 0, pressureFormat)},
+		{"Sea level pressure", formatReading(ConvertPressure(p.SeaLevelPressure.Value, units)*pressureScale, p.SeaLevelPressure.Value != 0, pressureFormat)},
+		{"Visibility", formatReading(ConvertDistance(p.Visibility.Value, units)*distanceScale, p.Visibility.Value != 0, distanceFormat)},
+	}
+}
+
+// sunRows formats the sun position, with the sunrise and sunset in the
+// local timezone.
+func sunRows(s SunPosition) []statusRow {
+	event := func(t time.Time) string {
+		if t.IsZero() {
+			return "n/a"
+		}
+		return t.In(localZone).Format(statusTimeFormat)
+	}
+	daylight := "no"
+	if s.IsDaylight {
+		daylight = "yes"
+	}
+	return []statusRow{
+		{"Altitude", fmt.Sprintf("%.1f°", s.Altitude)},
+		{"Azimuth", fmt.Sprintf("%.1f° %s", s.Azimuth, CardinalDirection(s.Azimuth, compasspoints))},
+		{"Daylight", daylight},
+		{"Sunrise", event(s.Sunrise)},
+		{"Sunset", event(s.Sunset)},
+	}
+}
+
+// statusHandler serves a human readable HTML page of the last scraped
+// observation, the sun position and recent errors, which refreshes itself
+// every scrape interval. Like /api/conditions it never calls the NWS api.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	// The default mux routes every unmatched path here
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := statusPage{Refresh: backofftime, Errors: RecentErrors()}
+	for i := range page.Errors {
+		page.Errors[i].Time = page.Errors[i].Time.In(localZone)
+	}
+	if c, ok := LastConditions(); ok {
+		page.Scraped = true
+		page.Station = c.Station
+		page.Description = c.Observation.TextDescription
+		page.ScrapedAt = c.ScrapedAt.In(localZone).Format(statusTimeFormat)
+		page.ObservedAt = c.Observation.Timestamp.In(localZone).Format(statusTimeFormat)
+		page.Readings = observationRows(c.Observation)
+		if c.Sun != nil {
+			page.Sun = sunRows(*c.Sun)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, page); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
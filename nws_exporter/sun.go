@@ -2,50 +2,178 @@ package main
 
 import (
 	"math"
+	"sync"
 	"time"
 )
 
-// Coordinates for Maui (PHOG - Kahului Airport)
-const (
-	latitude  = 20.8986  // degrees North
+// Coordinates for Maui (PHOG - Kahului Airport), unless set with -latitude
+// and -longitude or replaced at startup by -autodetect. Once the scrape loop
+// is running /reload can change them, so they are read through
+// activeCoordinates
+var (
+	latitude  = 20.8986   // degrees North
 	longitude = -156.4306 // degrees West
 )
 
+// Hawaii Standard Time, the local timezone for the coordinates above. With
+// -autotimezone this is replaced at startup by the zone looked up for them,
+// or by the system timezone if the lookup fails.
+var localZone = time.FixedZone("HST", -10*3600)
+
+// daylightAngle is the sun altitude in degrees above which IsDaylight is
+// set. The default matches sunrise and sunset, accounting for atmospheric
+// refraction; -6 would be civil twilight.
+var daylightAngle = -0.833
+
+// observerElevation is the station elevation in meters, which lowers the
+// visible horizon and so makes the sun rise earlier and set later
+var (
+	observerElevationMu sync.Mutex
+	observerElevation   float64
+)
+
+// SetObserverElevation sets the elevation in meters used for the horizon dip.
+func SetObserverElevation(meters float64) {
+	observerElevationMu.Lock()
+	defer observerElevationMu.Unlock()
+	observerElevation = meters
+}
+
+// horizonDip returns the geometric dip of the horizon in degrees for the
+// observer elevation, approximately 1.76 arcminutes times the square root of
+// the height in meters.
+func horizonDip() float64 {
+	observerElevationMu.Lock()
+	defer observerElevationMu.Unlock()
+	if observerElevation <= 0 {
+		return 0
+	}
+	return 1.76 * math.Sqrt(observerElevation) / 60
+}
+
 // SunPosition calculates the sun's altitude and azimuth for the given time
 type SunPosition struct {
-	Altitude float64 // degrees above horizon (negative = below)
-	Azimuth  float64 // degrees from North (0=N, 90=E, 180=S, 270=W)
-	IsDaylight bool
-	Sunrise  time.Time
-	Sunset   time.Time
+	Altitude          float64   `json:"altitude"`   // degrees above horizon (negative = below)
+	Azimuth           float64   `json:"azimuth"`    // degrees from North (0=N, 90=E, 180=S, 270=W)
+	HourAngle         float64   `json:"hour_angle"` // degrees west of the meridian (negative before solar noon)
+	IsDaylight        bool      `json:"is_daylight"`
+	Sunrise           time.Time `json:"sunrise"`
+	Sunset            time.Time `json:"sunset"`
+	NextSunrise       time.Time `json:"next_sunrise"`            // first sunrise after the given time
+	NextSunset        time.Time `json:"next_sunset"`             // first sunset after the given time
+	Distance          float64   `json:"distance_au"`             // Earth-Sun distance in astronomical units
+	AngularDiameter   float64   `json:"angular_diameter_arcmin"` // apparent diameter of the solar disk
+	JulianDay         float64   `json:"julian_day"`
+	SiderealTime      float64   `json:"local_sidereal_time"`       // local sidereal time in degrees
+	DaysToSolstice    float64   `json:"days_to_solstice"`          // days until the next solstice
+	DaysToEquinox     float64   `json:"days_to_equinox"`           // days until the next equinox
+	AltitudeRate      float64   `json:"altitude_rate_deg_per_min"` // positive while the sun is rising
+	Season            string    `json:"season"`                    // astronomical season at the coordinates
+	BetweenRiseSet    bool      `json:"between_rise_set"`          // after today's sunrise and before its sunset
+	AngleOfIncidence  float64   `json:"angle_of_incidence"`        // degrees between the sun and the panel normal
+	ApparentSolarTime float64   `json:"apparent_solar_time"`       // seconds since local solar midnight, as a sundial reads
 }
 
 // CalculateSunPosition computes the sun position for the current time
 func CalculateSunPosition(t time.Time) SunPosition {
 	// Calculate sunrise/sunset for Hawaii local date first
 	// This ensures we always get today's times in local timezone
-	hst := time.FixedZone("HST", -10*3600) // Hawaii Standard Time
-	localTime := t.In(hst)
+	latitude, longitude := activeCoordinates()
+	localTime := t.In(localZone)
 	sunrise, sunset := calculateSunriseSunset(localTime, latitude, longitude)
-	
+	nextSunrise, nextSunset := nextSunEvents(localTime)
+
 	// Convert to UTC for sun position calculation
 	t = t.UTC()
-	
+
 	// Calculate Julian day
 	jd := toJulianDay(t)
-	
+
 	// Calculate sun position
-	alt, az := sunPosition(jd, latitude, longitude)
-	
-	isDaylight := alt > -0.833 // Account for atmospheric refraction
-	
+	alt, az, ha := sunPosition(jd, latitude, longitude)
+	distance := sunDistance(jd)
+	// Differencing a minute ahead is plenty accurate, the rate changes slowly
+	nextAlt, _, _ := sunPosition(jd+1.0/1440, latitude, longitude)
+
+	isDaylight := alt > daylightAngle-horizonDip() // Account for horizon dip
+	// Without a sunrise and sunset today (polar day or night) only the
+	// altitude can tell
+	betweenRiseSet := isDaylight
+	if !sunrise.IsZero() && !sunset.IsZero() {
+		betweenRiseSet = !t.Before(sunrise) && t.Before(sunset)
+	}
+
 	return SunPosition{
-		Altitude: alt,
-		Azimuth: az,
-		IsDaylight: isDaylight,
-		Sunrise: sunrise,
-		Sunset: sunset,
+		Altitude:          alt,
+		Azimuth:           az,
+		HourAngle:         ha,
+		IsDaylight:        isDaylight,
+		Sunrise:           sunrise,
+		Sunset:            sunset,
+		NextSunrise:       nextSunrise,
+		NextSunset:        nextSunset,
+		Distance:          distance,
+		AngularDiameter:   sunAngularDiameter(distance),
+		JulianDay:         jd,
+		SiderealTime:      localSiderealTime(jd, longitude),
+		DaysToSolstice:    daysUntilLongitude(jd, 90, 270),
+		AltitudeRate:      nextAlt - alt,
+		DaysToEquinox:     daysUntilLongitude(jd, 0, 180),
+		Season:            astronomicalSeason(jd, latitude),
+		BetweenRiseSet:    betweenRiseSet,
+		AngleOfIncidence:  AngleOfIncidence(alt, az, panelTilt, panelAzimuth),
+		ApparentSolarTime: apparentSolarTime(ha),
+	}
+}
+
+// nextSunEvents returns the first sunrise and sunset after t, looking up to
+// a couple of days ahead so the events roll over to tomorrow once today's
+// have passed. Either is zero if the sun doesn't rise or set in that window.
+func nextSunEvents(t time.Time) (sunrise, sunset time.Time) {
+	latitude, longitude := activeCoordinates()
+	for i := 0; i < 3 && (sunrise.IsZero() || sunset.IsZero()); i++ {
+		rise, set := calculateSunriseSunset(t.AddDate(0, 0, i), latitude, longitude)
+		if sunrise.IsZero() && rise.After(t) {
+			sunrise = rise
+		}
+		if sunset.IsZero() && set.After(t) {
+			sunset = set
+		}
+	}
+	return sunrise, sunset
+}
+
+// SunDay summarizes the sun's daily events for a single date
+type SunDay struct {
+	Date      string    `json:"date"`
+	Sunrise   time.Time `json:"sunrise"`
+	Sunset    time.Time `json:"sunset"`
+	SolarNoon time.Time `json:"solar_noon"`
+	DayLength float64   `json:"day_length_seconds"`
+}
+
+// SunForecast computes sunrise, sunset, solar noon and day length for the
+// given number of days starting with the local date of t
+func SunForecast(t time.Time, days int) []SunDay {
+	latitude, longitude := activeCoordinates()
+	localTime := t.In(localZone)
+	forecast := make([]SunDay, 0, days)
+	for i := 0; i < days; i++ {
+		day := localTime.AddDate(0, 0, i)
+		sunrise, sunset := calculateSunriseSunset(day, latitude, longitude)
+		sd := SunDay{
+			Date:    day.Format("2006-01-02"),
+			Sunrise: sunrise,
+			Sunset:  sunset,
+		}
+		if !sunrise.IsZero() && !sunset.IsZero() {
+			length := sunset.Sub(sunrise)
+			sd.SolarNoon = sunrise.Add(length / 2)
+			sd.DayLength = length.Seconds()
+		}
+		forecast = append(forecast, sd)
 	}
+	return forecast
 }
 
 // toJulianDay converts a time to Julian Day
@@ -56,116 +184,245 @@ func toJulianDay(t time.Time) float64 {
 	hour := t.Hour()
 	minute := t.Minute()
 	second := t.Second()
-	
+
 	if month <= 2 {
 		year--
 		month += 12
 	}
-	
+
 	a := year / 100
 	b := 2 - a + a/4
-	
+
 	jd := float64(int(365.25*float64(year+4716))) +
 		float64(int(30.6001*float64(month+1))) +
 		float64(day) + float64(b) - 1524.5
-	
+
 	dayFraction := (float64(hour) + float64(minute)/60.0 + float64(second)/3600.0) / 24.0
-	
+
 	return jd + dayFraction
 }
 
-// sunPosition calculates altitude and azimuth
-func sunPosition(jd, lat, lon float64) (altitude, azimuth float64) {
+// sunPosition calculates altitude, azimuth and hour angle, the latter
+// normalized to [-180, 180] degrees
+func sunPosition(jd, lat, lon float64) (altitude, azimuth, hourAngle float64) {
 	// Calculate number of days since J2000.0
 	n := jd - 2451545.0
-	
+
 	// Mean longitude of the Sun
 	L := math.Mod(280.460+0.9856474*n, 360.0)
-	
+
 	// Mean anomaly of the Sun
 	g := math.Mod(357.528+0.9856003*n, 360.0)
 	gRad := g * math.Pi / 180.0
-	
+
 	// Ecliptic longitude
 	lambda := L + 1.915*math.Sin(gRad) + 0.020*math.Sin(2*gRad)
 	lambdaRad := lambda * math.Pi / 180.0
-	
+
 	// Obliquity of ecliptic
 	epsilon := 23.439 - 0.0000004*n
 	epsilonRad := epsilon * math.Pi / 180.0
-	
+
 	// Right ascension
 	alpha := math.Atan2(math.Cos(epsilonRad)*math.Sin(lambdaRad), math.Cos(lambdaRad))
-	
+
 	// Declination
 	delta := math.Asin(math.Sin(epsilonRad) * math.Sin(lambdaRad))
-	
-	// Greenwich Mean Sidereal Time
-	gmst := math.Mod(280.460+360.9856474*n, 360.0)
-	
+
 	// Local sidereal time
-	lst := gmst + lon
+	lst := localSiderealTime(jd, lon)
 	lstRad := lst * math.Pi / 180.0
-	
+
 	// Hour angle
 	h := lstRad - alpha
-	
+
 	// Convert latitude to radians
 	latRad := lat * math.Pi / 180.0
-	
+
 	// Calculate altitude
 	sinAlt := math.Sin(latRad)*math.Sin(delta) + math.Cos(latRad)*math.Cos(delta)*math.Cos(h)
 	altitude = math.Asin(sinAlt) * 180.0 / math.Pi
-	
+
 	// Calculate azimuth
 	cosAz := (math.Sin(delta) - math.Sin(latRad)*sinAlt) / (math.Cos(latRad) * math.Cos(math.Asin(sinAlt)))
 	azimuth = math.Acos(cosAz) * 180.0 / math.Pi
-	
+
 	if math.Sin(h) > 0 {
 		azimuth = 360.0 - azimuth
 	}
-	
-	return altitude, azimuth
+
+	hourAngle = math.Mod(h*180.0/math.Pi, 360.0)
+	if hourAngle > 180.0 {
+		hourAngle -= 360.0
+	} else if hourAngle < -180.0 {
+		hourAngle += 360.0
+	}
+
+	return altitude, azimuth, hourAngle
+}
+
+// localSiderealTime returns the local sidereal time in degrees, in [0, 360),
+// for the given Julian day and longitude.
+func localSiderealTime(jd, lon float64) float64 {
+	// Greenwich Mean Sidereal Time
+	gmst := math.Mod(280.460+360.9856474*(jd-2451545.0), 360.0)
+	lst := math.Mod(gmst+lon, 360.0)
+	if lst < 0 {
+		lst += 360.0
+	}
+	return lst
+}
+
+// sunDistance returns the Earth-Sun distance in astronomical units, from the
+// eccentricity correction to the sun's mean anomaly used in sunPosition.
+func sunDistance(jd float64) float64 {
+	n := jd - 2451545.0
+	gRad := math.Mod(357.528+0.9856003*n, 360.0) * math.Pi / 180.0
+	return 1.00014 - 0.01671*math.Cos(gRad) - 0.00014*math.Cos(2*gRad)
+}
+
+// apparentSolarTime returns the seconds since local apparent solar midnight
+// for the sun's hour angle in degrees. The sun moves 15 degrees an hour and
+// is on the meridian at solar noon, so this is the time a sundial shows, and
+// differs from clock time by the equation of time and the longitude's offset
+// from the timezone meridian.
+func apparentSolarTime(hourAngle float64) float64 {
+	seconds := math.Mod((hourAngle/15+12)*3600, 86400)
+	if seconds < 0 {
+		seconds += 86400
+	}
+	return seconds
+}
+
+// sunEclipticLongitude returns the sun's apparent ecliptic longitude in
+// degrees, in [0, 360), using the same approximation as sunPosition. It is 0
+// and 180 at the equinoxes and 90 and 270 at the solstices.
+func sunEclipticLongitude(jd float64) float64 {
+	n := jd - 2451545.0
+	L := 280.460 + 0.9856474*n
+	gRad := (357.528 + 0.9856003*n) * math.Pi / 180.0
+	lambda := math.Mod(L+1.915*math.Sin(gRad)+0.020*math.Sin(2*gRad), 360.0)
+	if lambda < 0 {
+		lambda += 360.0
+	}
+	return lambda
+}
+
+// sunDeclination returns the sun's declination in degrees for the given
+// Julian day, using the same approximation as sunPosition.
+func sunDeclination(jd float64) float64 {
+	n := jd - 2451545.0
+	lambdaRad := sunEclipticLongitude(jd) * math.Pi / 180.0
+	epsilonRad := (23.439 - 0.0000004*n) * math.Pi / 180.0
+	return math.Asin(math.Sin(epsilonRad)*math.Sin(lambdaRad)) * 180.0 / math.Pi
+}
+
+// MaxAltitude returns the sun's altitude in degrees at solar transit on t's
+// local date, 90 - |latitude - declination|. It is negative on days the sun
+// doesn't rise, and refraction is ignored.
+func MaxAltitude(t time.Time) float64 {
+	year, month, day := t.In(localZone).Date()
+	noon := time.Date(year, month, day, 12, 0, 0, 0, localZone)
+	latitude, _ := activeCoordinates()
+	return 90 - math.Abs(latitude-sunDeclination(toJulianDay(noon.UTC())))
+}
+
+// seasons are the astronomical seasons in the northern hemisphere, in order
+// from the March equinox.
+var seasons = []string{"spring", "summer", "autumn", "winter"}
+
+// astronomicalSeason returns the season at the given latitude, from the
+// quadrant of the sun's ecliptic longitude between the equinoxes and
+// solstices. The southern hemisphere's seasons are opposite.
+func astronomicalSeason(jd, lat float64) string {
+	quadrant := int(sunEclipticLongitude(jd) / 90)
+	if lat < 0 {
+		quadrant += 2
+	}
+	return seasons[quadrant%4]
+}
+
+// daysUntilLongitude returns the days from the given Julian day until the
+// sun's ecliptic longitude next reaches any of targets, in degrees. It walks
+// forward a day at a time to find the crossing and then bisects it to within
+// about a minute.
+func daysUntilLongitude(jd float64, targets ...float64) float64 {
+	// crossed reports whether a target lies in (from, to], going the short
+	// way forward around the circle
+	crossed := func(from, to float64) bool {
+		step := math.Mod(to-from+360.0, 360.0)
+		for _, target := range targets {
+			if d := math.Mod(target-from+360.0, 360.0); d > 0 && d <= step {
+				return true
+			}
+		}
+		return false
+	}
+	start := sunEclipticLongitude(jd)
+	for day := 0.0; day < 366; day++ {
+		if !crossed(start, sunEclipticLongitude(jd+day+1)) {
+			continue
+		}
+		lo, hi := day, day+1
+		for hi-lo > 1.0/1440 {
+			mid := (lo + hi) / 2
+			if crossed(start, sunEclipticLongitude(jd+mid)) {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		return hi
+	}
+	return 0
+}
+
+// sunAngularDiameter returns the apparent diameter in arcminutes of the solar
+// disk seen from the given distance in astronomical units.
+func sunAngularDiameter(distanceAU float64) float64 {
+	// Solar radius in astronomical units
+	const sunRadius = 0.00465047
+	return 2 * math.Atan(sunRadius/distanceAU) * 180.0 / math.Pi * 60.0
 }
 
 // calculateSunriseSunset calculates sunrise and sunset times for the given date
 func calculateSunriseSunset(t time.Time, lat, lon float64) (sunrise, sunset time.Time) {
 	// Use civil twilight (-6 degrees)
-	zenith := 90.833
-	
+	zenith := 90.833 + horizonDip()
+
 	// Always calculate for today in local time
-	local := t.Local()
+	local := t.In(localZone)
 	year, month, day := local.Date()
 	dayOfYear := local.YearDay()
-	
+
 	// Approximate times
 	lngHour := lon / 15.0
-	
+
 	// Sunrise
 	tSunrise := float64(dayOfYear) + ((6.0 - lngHour) / 24.0)
-	
+
 	// Sun's mean anomaly
 	M := (0.9856 * tSunrise) - 3.289
-	
+
 	// Sun's true longitude
 	L := math.Mod(M+(1.916*math.Sin(M*math.Pi/180.0))+(0.020*math.Sin(2*M*math.Pi/180.0))+282.634, 360.0)
-	
+
 	// Sun's right ascension
 	RA := math.Mod(math.Atan(0.91764*math.Tan(L*math.Pi/180.0))*180.0/math.Pi, 360.0)
-	
+
 	// Right ascension value needs to be in the same quadrant as L
-	Lquadrant := math.Floor(L / 90.0) * 90.0
-	RAquadrant := math.Floor(RA / 90.0) * 90.0
+	Lquadrant := math.Floor(L/90.0) * 90.0
+	RAquadrant := math.Floor(RA/90.0) * 90.0
 	RA = RA + (Lquadrant - RAquadrant)
 	RA = RA / 15.0
-	
+
 	// Sun's declination
 	sinDec := 0.39782 * math.Sin(L*math.Pi/180.0)
 	cosDec := math.Cos(math.Asin(sinDec))
-	
+
 	// Sun's local hour angle
 	cosH := (math.Cos(zenith*math.Pi/180.0) - (sinDec * math.Sin(lat*math.Pi/180.0))) / (cosDec * math.Cos(lat*math.Pi/180.0))
-	
+
 	if cosH > 1 {
 		// Sun never rises
 		return time.Time{}, time.Time{}
@@ -174,24 +431,24 @@ func calculateSunriseSunset(t time.Time, lat, lon float64) (sunrise, sunset time
 		// Sun never sets
 		return time.Time{}, time.Time{}
 	}
-	
+
 	H := 360.0 - (math.Acos(cosH) * 180.0 / math.Pi)
 	H = H / 15.0
-	
+
 	// Local mean time of rising
 	T := H + RA - (0.06571 * tSunrise) - 6.622
-	
+
 	// Adjust to UTC
 	UT := math.Mod(T-lngHour, 24.0)
 	if UT < 0 {
 		UT += 24.0
 	}
-	
+
 	// Convert to time
 	hours := int(UT)
 	minutes := int((UT - float64(hours)) * 60.0)
-	sunrise = time.Date(year, month, day, hours, minutes, 0, 0, time.UTC).Local()
-	
+	sunrise = time.Date(year, month, day, hours, minutes, 0, 0, time.UTC).In(localZone)
+
 	// Sunset calculation (similar but with different hour angle)
 	H = (math.Acos(cosH) * 180.0 / math.Pi) / 15.0
 	T = H + RA - (0.06571 * tSunrise) - 6.622
@@ -199,16 +456,16 @@ func calculateSunriseSunset(t time.Time, lat, lon float64) (sunrise, sunset time
 	if UT < 0 {
 		UT += 24.0
 	}
-	
+
 	hours = int(UT)
 	minutes = int((UT - float64(hours)) * 60.0)
 	sunset = time.Date(year, month, day, hours, minutes, 0, 0, time.UTC)
-	
+
 	// If sunset UTC hour is less than sunrise UTC hour, it's the next day in UTC
 	if sunset.Before(sunrise) {
 		sunset = sunset.Add(24 * time.Hour)
 	}
-	sunset = sunset.Local()
-	
+	sunset = sunset.In(localZone)
+
 	return sunrise, sunset
 }
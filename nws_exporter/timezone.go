@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PointResponse is the subset of the national weather service points api
+// response we use.
+type PointResponse struct {
+	Properties struct {
+		TimeZone string `json:"timeZone"`
+		// ObservationStations is the url listing the nearest observation
+		// stations, closest first.
+		ObservationStations string `json:"observationStations"`
+		// Forecast is the url of the gridpoint's daily forecast.
+		Forecast string `json:"forecast"`
+	} `json:"properties"`
+}
+
+// RetrieveTimezone looks up the IANA timezone for the given coordinates
+// using the NWS points api, e.g. Pacific/Honolulu for Maui. The zone is
+// loaded from the system's zoneinfo, which minimal containers need the
+// tzdata package for.
+func RetrieveTimezone(client *http.Client, address string, lat, lon float64, timeout time.Duration) (*time.Location, error) {
+	var point PointResponse
+	if err := fetchJSON(client, address, fmt.Sprintf("/points/%.4f,%.4f", lat, lon), timeout, &point); err != nil {
+		return nil, err
+	}
+	if point.Properties.TimeZone == "" {
+		return nil, fmt.Errorf("no timezone for %.4f,%.4f", lat, lon)
+	}
+	return time.LoadLocation(point.Properties.TimeZone)
+}
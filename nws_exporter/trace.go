@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// newPhaseTrace returns a ClientTrace that observes how long the DNS lookup,
+// TCP connect and TLS handshake of a request take, and the time from the
+// start of the request to the first response byte, in httpPhaseSeconds.
+// Phases that don't happen, such as on a reused connection, aren't observed.
+func newPhaseTrace() *httptrace.ClientTrace {
+	var mu sync.Mutex
+	start := time.Now()
+	var dnsStart, tlsStart time.Time
+	connectStarts := map[string]time.Time{}
+
+	observe := func(phase string, since time.Time) {
+		if !since.IsZero() {
+			httpPhaseSeconds.WithLabelValues(phase).Observe(time.Since(since).Seconds())
+		}
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			observe("dns", dnsStart)
+		},
+		// Connects can race each other when dialing both IPv6 and IPv4
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connectStarts[addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				observe("connect", connectStarts[addr])
+			}
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				observe("tls", tlsStart)
+			}
+		},
+		GotFirstResponseByte: func() {
+			observe("ttfb", start)
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "fmt"
+
+// Unit systems accepted by the -units flag. The national weather service
+// reports everything in metric, so metric values are passed through as-is.
+const (
+	unitsMetric   = "metric"
+	unitsImperial = "imperial"
+)
+
+// ValidateUnits returns an error if the given unit system is not one we know
+// how to convert to.
+func ValidateUnits(units string) error {
+	switch units {
+	case unitsMetric, unitsImperial:
+		return nil
+	default:
+		return fmt.Errorf("unknown units %q, expected %q or %q", units, unitsMetric, unitsImperial)
+	}
+}
+
+// ConvertTemperature converts a temperature in celsius to fahrenheit when
+// using imperial units.
+func ConvertTemperature(celsius float64, units string) float64 {
+	if units == unitsImperial {
+		return celsius*9/5 + 32
+	}
+	return celsius
+}
+
+// ConvertSpeed converts a speed in kilometers per hour to miles per hour when
+// using imperial units.
+func ConvertSpeed(kmh float64, units string) float64 {
+	if units == unitsImperial {
+		return kmh / 1.609344
+	}
+	return kmh
+}
+
+// ConvertPressure converts a pressure in pascals to inches of mercury when
+// using imperial units.
+func ConvertPressure(pascals float64, units string) float64 {
+	if units == unitsImperial {
+		return pascals / 3386.389
+	}
+	return pascals
+}
+
+// pressureUnits are the units nws_pressure and nws_pressure_sealevel are
+// exported in all at once, with the factor converting pascals to each.
+var pressureUnits = []struct {
+	name      string
+	perPascal float64
+}{
+	{"pa", 1},
+	{"hpa", 0.01},
+	{"mb", 0.01},
+	{"inhg", 1 / 3386.389},
+}
+
+// ConvertDistance converts a horizontal distance in meters, such as
+// visibility, to statute miles when using imperial units.
+func ConvertDistance(meters float64, units string) float64 {
+	if units == unitsImperial {
+		return meters / 1609.344
+	}
+	return meters
+}
+
+// ConvertHeight converts a height in meters, such as a cloud base, to feet
+// when using imperial units.
+func ConvertHeight(meters float64, units string) float64 {
+	if units == unitsImperial {
+		return meters / 0.3048
+	}
+	return meters
+}
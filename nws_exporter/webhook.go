@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// webhookCheckInterval is how often watchSunEvents checks for a change in
+// daylight, and so roughly how late a webhook can fire.
+const webhookCheckInterval = 30 * time.Second
+
+// SunEvent is the JSON payload posted to the -webhook URL.
+type SunEvent struct {
+	Event    string    `json:"event"` // sunrise or sunset
+	Time     time.Time `json:"time"`
+	Altitude float64   `json:"altitude"`
+	Azimuth  float64   `json:"azimuth"`
+}
+
+// watchSunEvents posts a SunEvent to webhookURL whenever the sun rises or
+// sets, i.e. when the sun position's IsDaylight flips. Each event fires at
+// most once per local day, so an altitude hovering around the threshold
+// doesn't fire repeatedly. It never returns.
+func watchSunEvents(client *http.Client, webhookURL string) {
+	// local date each event last fired on
+	fired := map[string]string{}
+	daylight := CalculateSunPosition(offsetNow()).IsDaylight
+	for range time.Tick(webhookCheckInterval) {
+		now := offsetNow()
+		pos := CalculateSunPosition(now)
+		if pos.IsDaylight == daylight {
+			continue
+		}
+		daylight = pos.IsDaylight
+
+		event := "sunset"
+		if daylight {
+			event = "sunrise"
+		}
+		date := now.In(localZone).Format("2006-01-02")
+		if fired[event] == date {
+			continue
+		}
+		fired[event] = date
+
+		if err := postSunEvent(client, webhookURL, SunEvent{
+			Event:    event,
+			Time:     now,
+			Altitude: pos.Altitude,
+			Azimuth:  pos.Azimuth,
+		}); err != nil {
+			log.Printf("Problem posting %s to webhook: %v", event, err)
+		}
+	}
+}
+
+// postSunEvent posts event as JSON to webhookURL.
+func postSunEvent(client *http.Client, webhookURL string, event SunEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("err: %d", resp.StatusCode)
+	}
+	return nil
+}
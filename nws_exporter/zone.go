@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// StationList is the json structure returned by the national weather service
+// station list apis.
+type StationList struct {
+	Features []struct {
+		Properties struct {
+			StationIdentifier string `json:"stationIdentifier"`
+			Name              string `json:"name"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// zoneStationsInterval is how often the stations in the zone are listed
+// again, to pick up stations added to or removed from it.
+const zoneStationsInterval = 24 * time.Hour
+
+// maxZoneStations caps how many stations of the zone are polled, so a large
+// zone can't multiply the load on NWS without bound.
+const maxZoneStations = 20
+
+// zoneStations caches the stations in the configured zone, which rarely
+// change, and zoneStationsListed is when they were last listed.
+var (
+	zoneStations       []string
+	zoneStationsListed time.Time
+)
+
+// RetrieveZoneStations returns the identifiers of the observation stations
+// in the given NWS forecast zone, e.g. HIZ017.
+func RetrieveZoneStations(client *http.Client, zone string, address string, timeout time.Duration) ([]string, error) {
+	var list StationList
+	if err := fetchJSON(client, address, fmt.Sprintf("/zones/forecast/%s/stations", zone), timeout, &list); err != nil {
+		return nil, err
+	}
+
+	stations := make([]string, 0, len(list.Features))
+	for _, f := range list.Features {
+		if f.Properties.StationIdentifier != "" {
+			stations = append(stations, f.Properties.StationIdentifier)
+		}
+	}
+	if len(stations) == 0 {
+		return nil, fmt.Errorf("no stations found in zone %s", zone)
+	}
+	return stations, nil
+}
+
+// watchZone polls the zone every interval, on its own so that a zone with
+// many stations doesn't hold up the primary station's scrape. It never
+// returns.
+func watchZone(client *http.Client, zone string, interval time.Duration) {
+	for {
+		if err := pollZone(client, zone, observationOptions()); err != nil {
+			log.Printf("Problem retrieving zone %s: %v", zone, err)
+			recordError(fmt.Errorf("zone %s: %w", zone, err))
+		}
+		time.Sleep(interval)
+	}
+}
+
+// pollZone fetches the current observation for up to maxZoneStations
+// stations in the zone and sets the zone gauges to the mean of the non-null
+// readings. The stations are listed again every zoneStationsInterval, and a
+// failure to list them keeps the previous list.
+func pollZone(client *http.Client, zone string, opts ObservationOptions) error {
+	if time.Since(zoneStationsListed) >= zoneStationsInterval {
+		stations, err := RetrieveZoneStations(client, zone, address, endpointTimeout(metadatatimeout))
+		if err != nil && zoneStations == nil {
+			return err
+		}
+		if err != nil {
+			log.Printf("Problem listing zone %s stations, keeping the previous %d: %v", zone, len(zoneStations), err)
+		} else {
+			log.Printf("Zone %s has %d stations", zone, len(stations))
+			if len(stations) > maxZoneStations {
+				log.Printf("Warning: only polling the first %d stations of zone %s", maxZoneStations, zone)
+				stations = stations[:maxZoneStations]
+			}
+			zoneStations = stations
+		}
+		// A failed listing is also retried only after the interval, so NWS
+		// isn't asked again on every poll
+		zoneStationsListed = time.Now()
+	}
+
+	var tempSum, rhSum float64
+	var tempCount, rhCount int
+	results := RetrieveObservations(client, zoneStations, address, opts)
+	recordStations(zoneStations, results)
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		if t := result.Response.Properties.Temperature.Value; t != 0 {
+			tempSum += t
+			tempCount++
+		}
+		if rh := clampHumidity(result.Response.Properties.Station, result.Response.Properties.RelativeHumidity.Value); rh != 0 {
+			rhSum += rh
+			rhCount++
+		}
+	}
+
+	if tempCount == 0 && rhCount == 0 {
+		return fmt.Errorf("no observations retrieved for zone %s", zone)
+	}
+	if tempCount > 0 {
+		zoneTemperature.WithLabelValues(zone).Set(ConvertTemperature(tempSum/float64(tempCount), units))
+	}
+	if rhCount > 0 {
+		zoneHumidity.WithLabelValues(zone).Set(rhSum / float64(rhCount))
+	}
+	zoneStationsReporting.WithLabelValues(zone).Set(float64(tempCount))
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPollZone(t *testing.T) {
+	var listings, observations int32
+	stationCount := 25
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/zones/forecast/HIZ017/stations" {
+			atomic.AddInt32(&listings, 1)
+			var list StationList
+			list.Features = make([]struct {
+				Properties struct {
+					StationIdentifier string `json:"stationIdentifier"`
+					Name              string `json:"name"`
+				} `json:"properties"`
+			}, stationCount)
+			for i := range list.Features {
+				list.Features[i].Properties.StationIdentifier = fmt.Sprintf("ST%02d", i)
+			}
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		atomic.AddInt32(&observations, 1)
+		fmt.Fprint(w, `{"properties": {"timestamp": "2024-06-21T12:00:00+00:00", "temperature": {"value": 25}, "relativeHumidity": {"value": 60}}}`)
+	}))
+	defer srv.Close()
+
+	savedAddress := address
+	defer func() {
+		address = savedAddress
+		zoneStations, zoneStationsListed = nil, time.Time{}
+	}()
+	address = strings.TrimPrefix(srv.URL, "https://")
+	zoneStations, zoneStationsListed = nil, time.Time{}
+
+	if err := pollZone(srv.Client(), "HIZ017", ObservationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(zoneStations) != maxZoneStations || observations != maxZoneStations {
+		t.Errorf("polled %d of %d listed stations, want %d", observations, len(zoneStations), maxZoneStations)
+	}
+	if got := testutil.ToFloat64(zoneTemperature.WithLabelValues("HIZ017")); got != ConvertTemperature(25, units) {
+		t.Errorf("zone temperature = %v, want 25°C", got)
+	}
+
+	// The list is only fetched again once zoneStationsInterval has passed
+	stationCount = 3
+	if err := pollZone(srv.Client(), "HIZ017", ObservationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if listings != 1 {
+		t.Errorf("zone listed %d times, want once", listings)
+	}
+	zoneStationsListed = time.Now().Add(-zoneStationsInterval)
+	if err := pollZone(srv.Client(), "HIZ017", ObservationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if listings != 2 || len(zoneStations) != 3 {
+		t.Errorf("after %d listings the zone has %d stations, want 2 listings of 3", listings, len(zoneStations))
+	}
+}